@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The address book is every node we have heard of, learnt from the seed nodes, from peers'
+// version messages and from addr gossip. It is saved alongside the wallet so a restarted node can
+// reconnect without the seeds. A node that can't be reached isn't forgotten, it is just tried
+// again less and less often.
+const addrBookFile = "peers_%s.dat"
+
+// maxKnownAddresses caps the address book, the least promising addresses are dropped beyond it
+const maxKnownAddresses = 2000
+
+// Addresses that failed to connect are retried after retryBaseDelay, doubling with each further
+// failure up to maxRetryDelay
+const retryBaseDelay = 30 * time.Second
+const maxRetryDelay = 4 * time.Hour
+
+// KnownAddress is what we know about a node listening on Addr
+type KnownAddress struct {
+	Addr        string
+	Services    uint64
+	LastSeen    time.Time // when the node was last known to be up, by us or by the peer that told us
+	LastAttempt time.Time // when we last tried to connect
+	LastSuccess time.Time // when we last completed a handshake with it
+	Failures    int       // connection attempts since the last success, failed or still in progress
+}
+
+// retryAt is when the address may next be tried
+func (ka *KnownAddress) retryAt() time.Time {
+	if ka.Failures == 0 {
+		return ka.LastAttempt
+	}
+	delay := maxRetryDelay
+	if ka.Failures < 16 {
+		delay = retryBaseDelay << (ka.Failures - 1)
+		if delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+	return ka.LastAttempt.Add(delay)
+}
+
+// better reports whether ka is more worth connecting to than other: fewer recent failures first,
+// then the most recently seen
+func (ka *KnownAddress) better(other *KnownAddress) bool {
+	if ka.Failures != other.Failures {
+		return ka.Failures < other.Failures
+	}
+	return ka.LastSeen.After(other.LastSeen)
+}
+
+type AddressBook struct {
+	path      string
+	addresses map[string]*KnownAddress
+	mutex     sync.Mutex
+}
+
+func NewAddressBook(path string) *AddressBook {
+	return &AddressBook{path: path, addresses: make(map[string]*KnownAddress)}
+}
+
+// LoadAddressBook reads the address book saved at path, or starts an empty one if there is none
+func LoadAddressBook(path string) (*AddressBook, error) {
+	book := NewAddressBook(path)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return book, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var addresses []*KnownAddress
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&addresses); err != nil {
+		return nil, err
+	}
+	for _, ka := range addresses {
+		book.addresses[ka.Addr] = ka
+	}
+	return book, nil
+}
+
+// Save writes the address book back to its file
+func (book *AddressBook) Save() error {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	var addresses []*KnownAddress
+	for _, ka := range book.addresses {
+		addresses = append(addresses, ka)
+	}
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(addresses); err != nil {
+		return err
+	}
+	return os.WriteFile(book.path, buffer.Bytes(), 0644)
+}
+
+// Add records that a node listens on addr, returning true if it wasn't known before
+func (book *AddressBook) Add(addr string, services uint64, lastSeen time.Time) bool {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	if ka, found := book.addresses[addr]; found {
+		if lastSeen.After(ka.LastSeen) {
+			ka.LastSeen = lastSeen
+		}
+		ka.Services = ka.Services | services
+		return false
+	}
+	book.addresses[addr] = &KnownAddress{Addr: addr, Services: services, LastSeen: lastSeen}
+	if len(book.addresses) > maxKnownAddresses {
+		book.evict()
+	}
+	return true
+}
+
+// evict drops the least promising address
+func (book *AddressBook) evict() {
+	var worst *KnownAddress
+	for _, ka := range book.addresses {
+		if worst == nil || worst.better(ka) {
+			worst = ka
+		}
+	}
+	delete(book.addresses, worst.Addr)
+}
+
+// Attempt records that we are trying to connect to addr. It counts as a failure, pushing back
+// when the address will next be tried, until the handshake completes.
+func (book *AddressBook) Attempt(addr string) {
+	book.update(addr, func(ka *KnownAddress) {
+		ka.LastAttempt = time.Now()
+		ka.Failures++
+	})
+}
+
+// Good records a completed handshake with the node at addr
+func (book *AddressBook) Good(addr string, services uint64) {
+	book.update(addr, func(ka *KnownAddress) {
+		now := time.Now()
+		ka.Services = services
+		ka.LastSeen = now
+		ka.LastSuccess = now
+		ka.Failures = 0
+	})
+}
+
+func (book *AddressBook) update(addr string, change func(ka *KnownAddress)) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	ka, found := book.addresses[addr]
+	if !found {
+		ka = &KnownAddress{Addr: addr}
+		book.addresses[addr] = ka
+	}
+	change(ka)
+}
+
+// Get returns a copy of what is known about addr
+func (book *AddressBook) Get(addr string) (KnownAddress, bool) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	ka, found := book.addresses[addr]
+	if !found {
+		return KnownAddress{}, false
+	}
+	return *ka, true
+}
+
+// Candidates returns up to n addresses to connect to, best first. Addresses still backing off from
+// a failure, and those skip returns true for, are left out.
+func (book *AddressBook) Candidates(n int, skip func(addr string) bool) []string {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	now := time.Now()
+	var candidates []*KnownAddress
+	for _, ka := range book.addresses {
+		if ka.retryAt().After(now) || skip(ka.Addr) {
+			continue
+		}
+		candidates = append(candidates, ka)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].better(candidates[j])
+	})
+
+	var addrs []string
+	for i := 0; i < len(candidates) && i < n; i++ {
+		addrs = append(addrs, candidates[i].Addr)
+	}
+	return addrs
+}
+
+// Addresses returns up to n of the most recently seen addresses, to share with a peer
+func (book *AddressBook) Addresses(n int) []KnownAddress {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+
+	var addresses []KnownAddress
+	for _, ka := range book.addresses {
+		if !ka.LastSeen.IsZero() {
+			addresses = append(addresses, *ka)
+		}
+	}
+	sort.Slice(addresses, func(i, j int) bool {
+		return addresses[i].LastSeen.After(addresses[j].LastSeen)
+	})
+	if len(addresses) > n {
+		addresses = addresses[:n]
+	}
+	return addresses
+}
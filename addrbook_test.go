@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddressBook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "peers.dat")
+	book := NewAddressBook(path)
+	now := time.Now()
+
+	assert.True(t, book.Add("localhost:3001", serviceNetwork, now.Add(-time.Hour)))
+	assert.True(t, book.Add("localhost:3002", serviceNetwork, now))
+	assert.False(t, book.Add("localhost:3001", serviceNetworkLimited, now.Add(-time.Minute)), "Already known")
+	ka, _ := book.Get("localhost:3001")
+	assert.Equal(t, serviceNetwork|serviceNetworkLimited, ka.Services)
+	assert.Equal(t, now.Add(-time.Minute).Unix(), ka.LastSeen.Unix())
+
+	none := func(string) bool { return false }
+	assert.Equal(t, []string{"localhost:3002", "localhost:3001"}, book.Candidates(5, none), "Most recently seen first")
+
+	// A failed attempt isn't forgotten, just backed off
+	book.Attempt("localhost:3002")
+	assert.Equal(t, []string{"localhost:3001"}, book.Candidates(5, none))
+	ka, _ = book.Get("localhost:3002")
+	assert.Equal(t, 1, ka.Failures)
+	ka.Failures = 3
+	assert.Equal(t, 4*retryBaseDelay, ka.retryAt().Sub(ka.LastAttempt))
+	ka.Failures = 100
+	assert.Equal(t, maxRetryDelay, ka.retryAt().Sub(ka.LastAttempt))
+
+	book.Attempt("localhost:3001")
+	book.Good("localhost:3001", serviceNetwork)
+	assert.Equal(t, []string{"localhost:3001"}, book.Candidates(5, none), "A good address can be reconnected straight away")
+	skip := func(addr string) bool { return addr == "localhost:3001" }
+	assert.Empty(t, book.Candidates(5, skip))
+
+	assert.Nil(t, book.Save())
+	loaded, err := LoadAddressBook(path)
+	assert.Nil(t, err)
+	assert.Len(t, loaded.Addresses(10), 2)
+	ka, _ = loaded.Get("localhost:3002")
+	assert.Equal(t, 1, ka.Failures, "Failure counts are kept across restarts")
+}
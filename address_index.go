@@ -0,0 +1,31 @@
+package main
+
+// The address indexes sit alongside the chainstate and are keyed by pubkey hash, so lookups for
+// one address only touch that address's entries rather than the whole UTXO set. The index of
+// unspent outputs is kept up to date by PutUtxo and DeleteUtxo; the history is written here.
+
+// AddressHistoryEntry is a main chain transaction involving an address
+type AddressHistoryEntry struct {
+	Height int
+	TxID   []byte
+}
+
+// indexTxHistory records transaction against every address it pays to or spends from
+func indexTxHistory(tx ChainTx, transaction *Transaction, height int) error {
+	var hashes [][]byte
+	if !transaction.IsCoinbase() {
+		for _, input := range transaction.Inputs {
+			hashes = append(hashes, HashPubKey(input.PubKey))
+		}
+	}
+	for _, output := range transaction.Outputs {
+		hashes = append(hashes, output.PubKeyHash)
+	}
+
+	for _, pubKeyHash := range hashes {
+		if err := tx.PutHistory(pubKeyHash, height, transaction.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func hasAddressUtxo(t *testing.T, bc *Blockchain, address string, txID []byte, index int) bool {
+	found := false
+	err := bc.db.View(func(tx ChainTx) error {
+		outpoint := outpointKey(txID, index)
+		return tx.ForEachUtxoOf(ConvertBase58AddressToPubKeyHash(address), func(key []byte, _ UtxoEntry) error {
+			found = found || bytes.Equal(key, outpoint)
+			return nil
+		})
+	})
+	assert.Nil(t, err)
+	return found
+}
+
+func historyHeights(utxoSet UTXOSet, address string) []int {
+	var heights []int
+	for _, entry := range utxoSet.FindHistory(ConvertBase58AddressToPubKeyHash(address)) {
+		heights = append(heights, entry.Height)
+	}
+	return heights
+}
+
+func TestAddressIndex(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := newTestBlockchain(t, alice, false)
+	utxoSet := UTXOSet{bc}
+
+	pay := NewUtxoTransaction(wallets, alice, []Payment{{bob, 3}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	mineTx(bc, alice, pay)
+	assert.True(t, hasAddressUtxo(t, bc, bob, pay.ID, 0))
+	assert.Equal(t, 3, balanceOf(utxoSet, bob))
+	spendable := utxoSet.FindSpendableOutputs(ConvertBase58AddressToPubKeyHash(bob))
+	assert.Len(t, spendable, 1)
+	assert.Equal(t, pay.ID, spendable[0].TxID)
+	history := utxoSet.FindHistory(ConvertBase58AddressToPubKeyHash(bob))
+	assert.Equal(t, []AddressHistoryEntry{{1, pay.ID}}, history)
+	assert.Equal(t, []int{0, 1, 1}, historyHeights(utxoSet, alice), "Genesis reward, the payment it spent from and the block reward")
+
+	// Bob spends his output, so it leaves his unspent outputs but the payment stays in his history
+	spend := NewUtxoTransaction(wallets, bob, []Payment{{alice, 3}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	mineTx(bc, alice, spend)
+	assert.False(t, hasAddressUtxo(t, bc, bob, pay.ID, 0))
+	assert.Equal(t, 0, balanceOf(utxoSet, bob))
+	assert.Equal(t, []int{1, 2}, historyHeights(utxoSet, bob))
+
+	// Rebuilding the index gives the same answers as updating it block by block
+	utxoSet.Reindex()
+	assert.False(t, hasAddressUtxo(t, bc, bob, pay.ID, 0))
+	assert.True(t, hasAddressUtxo(t, bc, alice, spend.ID, 0))
+	assert.Equal(t, []int{1, 2}, historyHeights(utxoSet, bob))
+	assert.Equal(t, []int{0, 1, 1, 2, 2}, historyHeights(utxoSet, alice))
+}
+
+func TestAddressIndexIsBuiltForOldChainstates(t *testing.T) {
+	_, addresses := newTestWallets(1)
+	bc := newTestBlockchain(t, addresses[0], false)
+
+	// Chainstates from before the address index are rebuilt when the database is upgraded
+	err := bc.db.Update(func(tx ChainTx) error {
+		return tx.DeleteChainstate()
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 0, balanceOf(UTXOSet{bc}, addresses[0]))
+	setSchemaVersion(bc.db, 2)
+	bc.db.Close()
+
+	bc = NewBlockchain(testNodeID)
+	defer bc.db.Close()
+	utxoSet := UTXOSet{bc}
+	assert.Equal(t, 10, balanceOf(utxoSet, addresses[0]))
+	assert.Equal(t, []int{0}, historyHeights(utxoSet, addresses[0]))
+}
+
+func TestAddressIndexAfterReorg(t *testing.T) {
+	_, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := newTestBlockchain(t, alice, false)
+	utxoSet := UTXOSet{bc}
+	genesis := tipBlock(t, bc)
+
+	orphaned := bc.MineBlock([]*Transaction{NewCoinbaseTx(alice, "Block 1 reward to Alice")})
+	assert.True(t, hasAddressUtxo(t, bc, alice, orphaned.Transactions[0].ID, 0))
+	assert.Equal(t, []int{0, 1}, historyHeights(utxoSet, alice))
+
+	// Once Bob's branch takes over the chainstate is rebuilt, as it is after a sync
+	branch := addTestBranch(bc, bob, genesis, 2)
+	assert.Equal(t, branch[1].Hash, bc.tip)
+	utxoSet.Reindex()
+	assert.Equal(t, []int{0}, historyHeights(utxoSet, alice), "Alice's reward on the old branch is gone")
+	assert.Equal(t, 10, balanceOf(utxoSet, alice))
+	assert.Equal(t, []int{1, 2}, historyHeights(utxoSet, bob))
+	assert.Equal(t, 20, balanceOf(utxoSet, bob))
+	assert.False(t, hasAddressUtxo(t, bc, alice, orphaned.Transactions[0].ID, 0))
+}
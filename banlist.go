@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// The ban list is the hosts the node won't talk to, until a time. Peers that misbehave are banned
+// automatically, and hosts can be banned or unbanned by hand with setban. It is saved next to the
+// address book so bans outlast a restart, and a running node picks up changes made by setban.
+const banListFile = "banlist_%s.dat"
+
+// defaultBanDuration is how long a host is banned for if no other duration is given
+const defaultBanDuration = 24 * time.Hour
+
+// Ban is a host that is banned until a time
+type Ban struct {
+	Host  string
+	Until time.Time
+}
+
+type BanList struct {
+	path     string
+	bans     map[string]time.Time
+	modified time.Time // the modification time of the file when it was last read or written
+	mutex    sync.Mutex
+}
+
+func NewBanList(path string) *BanList {
+	return &BanList{path: path, bans: make(map[string]time.Time)}
+}
+
+// LoadBanList reads the ban list saved at path, or starts an empty one if there is none
+func LoadBanList(path string) (*BanList, error) {
+	list := NewBanList(path)
+	if err := list.load(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (list *BanList) load() error {
+	info, err := os.Stat(list.path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(list.path)
+	if err != nil {
+		return err
+	}
+
+	var bans []Ban
+	if err := gob.NewDecoder(bytes.NewReader(data)).Decode(&bans); err != nil {
+		return err
+	}
+	list.bans = make(map[string]time.Time)
+	for _, ban := range bans {
+		list.bans[ban.Host] = ban.Until
+	}
+	list.modified = info.ModTime()
+	return nil
+}
+
+// Refresh rereads the ban list if its file has been changed by someone else since it was last read
+// or written
+func (list *BanList) Refresh() error {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	info, err := os.Stat(list.path)
+	if os.IsNotExist(err) || err == nil && info.ModTime().Equal(list.modified) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return list.load()
+}
+
+// Save writes the ban list back to its file, leaving out bans that have expired
+func (list *BanList) Save() error {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(list.current()); err != nil {
+		return err
+	}
+	if err := os.WriteFile(list.path, buffer.Bytes(), 0644); err != nil {
+		return err
+	}
+	info, err := os.Stat(list.path)
+	if err != nil {
+		return err
+	}
+	list.modified = info.ModTime()
+	return nil
+}
+
+// Ban bans host until the given time
+func (list *BanList) Ban(host string, until time.Time) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.bans[host] = until
+}
+
+// Unban lifts the ban on host, returning false if it wasn't banned
+func (list *BanList) Unban(host string) bool {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+
+	_, found := list.bans[host]
+	delete(list.bans, host)
+	return found
+}
+
+// IsBanned reports whether host is banned now
+func (list *BanList) IsBanned(host string) bool {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	return time.Now().Before(list.bans[host])
+}
+
+// Bans returns the bans that haven't expired, soonest to expire first
+func (list *BanList) Bans() []Ban {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	return list.current()
+}
+
+func (list *BanList) current() []Ban {
+	now := time.Now()
+	var bans []Ban
+	for host, until := range list.bans {
+		if now.Before(until) {
+			bans = append(bans, Ban{host, until})
+		}
+	}
+	sort.Slice(bans, func(i, j int) bool {
+		return bans[i].Until.Before(bans[j].Until)
+	})
+	return bans
+}
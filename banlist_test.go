@@ -0,0 +1,39 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBanList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "banlist.dat")
+	list, err := LoadBanList(path)
+	assert.Nil(t, err)
+	assert.False(t, list.IsBanned("10.0.0.1"))
+
+	list.Ban("10.0.0.1", time.Now().Add(time.Hour))
+	list.Ban("10.0.0.2", time.Now().Add(-time.Second))
+	assert.True(t, list.IsBanned("10.0.0.1"))
+	assert.False(t, list.IsBanned("10.0.0.2"), "Bans expire")
+	assert.Nil(t, list.Save())
+
+	reloaded, err := LoadBanList(path)
+	assert.Nil(t, err)
+	assert.True(t, reloaded.IsBanned("10.0.0.1"), "Bans outlast a restart")
+	assert.Len(t, reloaded.Bans(), 1, "Expired bans aren't saved")
+
+	// A running node picks up a ban made by setban
+	reloaded.Ban("10.0.0.3", time.Now().Add(time.Hour))
+	assert.Nil(t, reloaded.Save())
+	future := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(path, future, future))
+	assert.Nil(t, list.Refresh())
+	assert.True(t, list.IsBanned("10.0.0.3"))
+
+	assert.True(t, list.Unban("10.0.0.3"))
+	assert.False(t, list.Unban("10.0.0.3"))
+	assert.False(t, list.IsBanned("10.0.0.3"))
+}
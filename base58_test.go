@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBase58LeadingZeros(t *testing.T) {
+	for _, data := range [][]byte{{0, 1, 2}, {0, 0, 0, 255}, {7, 0}} {
+		assert.Equal(t, data, Base58Decode(Base58Encode(data)))
+	}
+	assert.Equal(t, "111", string(Base58Encode([]byte{0, 0, 0})), "Each leading zero byte is a leading 1")
+}
@@ -16,6 +16,49 @@ type Block struct {
 	Height        int
 }
 
+// BlockHeader is everything about a block except its transactions (which are summarised by
+// the merkle root). Headers are kept for every block, even once the block itself is pruned.
+type BlockHeader struct {
+	Timestamp     int64
+	MerkleRoot    []byte
+	PrevBlockHash []byte
+	Hash          []byte
+	Nonce         int
+	Height        int
+}
+
+func (block *Block) Header() BlockHeader {
+	return BlockHeader{
+		Timestamp:     block.Timestamp,
+		MerkleRoot:    block.HashTransactions(),
+		PrevBlockHash: block.PrevBlockHash,
+		Hash:          block.Hash,
+		Nonce:         block.Nonce,
+		Height:        block.Height,
+	}
+}
+
+func (header *BlockHeader) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+
+	if err := encoder.Encode(header); err != nil {
+		panic("Unable to serialize BlockHeader")
+	}
+
+	return buffer.Bytes()
+}
+
+func DeserializeBlockHeader(data []byte) *BlockHeader {
+	var header BlockHeader
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&header); err != nil {
+		fmt.Println(err)
+		panic("Unable to deserialize header")
+	}
+	return &header
+}
+
 func (block *Block) Serialize() []byte {
 	var buffer bytes.Buffer
 	encoder := gob.NewEncoder(&buffer)
@@ -33,20 +76,29 @@ func (block *Block) Serialize() []byte {
 func (block *Block) HashTransactions() []byte {
 	var transactions [][]byte
 	for _, tx := range block.Transactions {
-		transactions = append(transactions, tx.Serialize())
+		transactions = append(transactions, tx.hashData())
 	}
 	mTree := NewMerkleTree(transactions)
 	return mTree.Root.Data
 }
 
 func DeserializeBlock(data []byte) *Block {
+	block, err := decodeBlock(data)
+	if err != nil {
+		fmt.Println(err)
+		panic("Unable to deserialize data")
+	}
+	return block
+}
+
+// decodeBlock deserializes a block that may be malformed, such as one we were sent
+func decodeBlock(data []byte) (*Block, error) {
 	var block Block
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	if err := decoder.Decode(&block); err != nil {
-		fmt.Println(err)
-		panic("Unable to deserialize data")
+		return nil, err
 	}
-	return &block
+	return &block, nil
 }
 
 func NewBlock(transactions []*Transaction, prevBlockHash []byte, height int) *Block {
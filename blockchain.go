@@ -6,7 +6,6 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"github.com/boltdb/bolt"
 	"golang.org/x/exp/slices"
 	"io/fs"
 	"log"
@@ -14,29 +13,30 @@ import (
 )
 
 const dbFile = "blockchain_%s.db"
-const blocksBucketName = "blocks"
 const genesisData = "Hello Blockchain!"
 
 type Blockchain struct {
 	tip []byte
-	db  *bolt.DB
+	db  ChainStore
 }
 
 type BlockchainIterator struct {
 	currentHash []byte
-	db          *bolt.DB
+	db          ChainStore
 }
 
 func (iterator *BlockchainIterator) Next() *Block {
 	var block *Block
 	// retrieve block
-	err := iterator.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucketName))
-		blockBytes := bucket.Get(iterator.currentHash)
-		block = DeserializeBlock(blockBytes)
+	err := iterator.db.View(func(tx ChainTx) error {
+		block = tx.GetBlock(iterator.currentHash)
 		return nil
 	})
 	if err != nil {
+		log.Panic(err)
+	}
+	// Returns nil once it reaches a block that has been pruned
+	if block == nil {
 		return nil
 	}
 	iterator.currentHash = block.PrevBlockHash
@@ -58,12 +58,9 @@ func (blockchain *Blockchain) MineBlock(transactions []*Transaction) *Block {
 		}
 	}
 
-	err := blockchain.db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucketName))
-		lastHash = bucket.Get([]byte("l"))
-		blockData := bucket.Get(lastHash)
-		block := DeserializeBlock(blockData)
-		lastHeight = block.Height
+	err := blockchain.db.View(func(tx ChainTx) error {
+		lastHash = tx.GetTip()
+		lastHeight = tx.GetHeader(lastHash).Height
 		return nil
 	})
 
@@ -71,48 +68,47 @@ func (blockchain *Blockchain) MineBlock(transactions []*Transaction) *Block {
 		log.Panic(err)
 	}
 
+	// The block is stored and applied to the UTXO set in one update, so a block whose spends can't
+	// be applied is never left as the tip
 	newBlock := NewBlock(transactions, lastHash, lastHeight+1)
-	blockchain.db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucketName))
-		err = bucket.Put(newBlock.Hash, newBlock.Serialize())
-		if err != nil {
-			log.Panic(err)
+	tip := blockchain.tip
+	err = blockchain.db.Update(func(tx ChainTx) error {
+		if err := tx.PutBlock(newBlock); err != nil {
+			return err
 		}
-		err = bucket.Put([]byte("l"), newBlock.Hash)
-		if err != nil {
-			log.Panic(err)
+		if err := blockchain.setTip(tx, newBlock); err != nil {
+			return err
 		}
-		blockchain.tip = newBlock.Hash
-		return nil
+		return updateUtxos(tx, newBlock)
 	})
+	if err != nil {
+		blockchain.tip = tip // the update was rolled back
+		log.Panic(err)
+	}
 
 	return newBlock
 }
 
 func (blockchain *Blockchain) GetBestHeight() int {
-	var lastBlock Block
-	err := blockchain.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucketName))
-		lastHash := b.Get([]byte("l"))
-		blockData := b.Get(lastHash)
-		lastBlock = *DeserializeBlock(blockData)
+	var height int
+	err := blockchain.db.View(func(tx ChainTx) error {
+		height = tx.GetHeader(tx.GetTip()).Height
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
-	return lastBlock.Height
+	return height
 }
 
 func (blockchain *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	var block Block
-	err := blockchain.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucketName))
-		blockData := b.Get(blockHash)
-		if blockData == nil {
+	err := blockchain.db.View(func(tx ChainTx) error {
+		stored := tx.GetBlock(blockHash)
+		if stored == nil {
 			return errors.New("Block is not found")
 		}
-		block = *DeserializeBlock(blockData)
+		block = *stored
 		return nil
 	})
 	if err != nil {
@@ -121,16 +117,32 @@ func (blockchain *Blockchain) GetBlock(blockHash []byte) (Block, error) {
 	return block, nil
 }
 
-func (blockchain *Blockchain) GetBlockHashes() [][]byte {
-	var blocks [][]byte
-	bci := blockchain.Iterator()
-	for {
-		block := bci.Next()
-		blocks = append(blocks, block.Hash)
-		if len(block.PrevBlockHash) == 0 {
-			break
+// GetBlockHeader returns the header of any block the chain knows about, including pruned blocks
+func (blockchain *Blockchain) GetBlockHeader(blockHash []byte) (BlockHeader, error) {
+	var header BlockHeader
+	err := blockchain.db.View(func(tx ChainTx) error {
+		stored := tx.GetHeader(blockHash)
+		if stored == nil {
+			return errors.New("Block header is not found")
 		}
-	}
+		header = *stored
+		return nil
+	})
+	return header, err
+}
+
+// buildHeaders stores the header of every block for chains created before headers were kept
+func buildHeaders(tx ChainTx) error {
+	return tx.ForEachBlock(func(block *Block) error {
+		header := block.Header()
+		return tx.PutHeader(&header)
+	})
+}
+
+// GetBlockHashes returns the hashes of every block on the main chain, from the tip back to genesis
+func (blockchain *Blockchain) GetBlockHashes() [][]byte {
+	blocks := blockchain.GetBlockHashesInRange(0, blockchain.GetBestHeight())
+	slices.Reverse(blocks)
 	return blocks
 }
 
@@ -141,39 +153,32 @@ func dbExists(dbFile string) bool {
 	return true
 }
 
-func CreateBlockchain(address string, nodeID string) *Blockchain {
+func CreateBlockchain(address string, nodeID string, withTxIndex bool) *Blockchain {
 	dbFile := fmt.Sprintf(dbFile, nodeID)
 	if dbExists(dbFile) {
 		fmt.Println("Blockchain already exists.")
 		os.Exit(1)
 	}
 
+	db, err := OpenBoltStore(dbFile)
+	if err != nil {
+		log.Panic(err)
+	}
+	return CreateBlockchainInStore(address, db, withTxIndex)
+}
+
+// CreateBlockchainInStore creates a new chain in db with a genesis block paying address
+func CreateBlockchainInStore(address string, db ChainStore, withTxIndex bool) *Blockchain {
 	var tip []byte
-	db, _ := bolt.Open(dbFile, 0600, nil)
-	err := db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucketName))
-		if bucket != nil {
-			tip = bucket.Get([]byte("l"))
-		} else {
+	blockchain := &Blockchain{db: db}
+	err := db.Update(func(tx ChainTx) error {
+		if tip = tx.GetTip(); tip == nil {
 			println("Creating Coinbase Tx")
 			coinbaseTx := NewCoinbaseTx(address, genesisData)
 			genesisBlock := NewGenesisBlock(coinbaseTx)
-			bucket, err := tx.CreateBucket([]byte(blocksBucketName))
-
-			if err != nil {
-				panic(err)
-			}
-
-			err = bucket.Put(genesisBlock.Hash, genesisBlock.Serialize())
-			if err != nil {
+			if err := blockchain.initChain(tx, genesisBlock, withTxIndex); err != nil {
 				panic(err)
 			}
-
-			err = bucket.Put([]byte("l"), genesisBlock.Hash)
-			if err != nil {
-				panic(err)
-			}
-
 			tip = genesisBlock.Hash
 		}
 		return nil
@@ -181,7 +186,40 @@ func CreateBlockchain(address string, nodeID string) *Blockchain {
 	if err != nil {
 		panic(err)
 	}
-	return &Blockchain{tip, db}
+	blockchain.tip = tip
+	return blockchain
+}
+
+// CreateBlockchainFromGenesis creates a new chain in the empty store db starting at an existing
+// genesis block, to follow a chain created elsewhere
+func CreateBlockchainFromGenesis(genesisBlock *Block, db ChainStore, withTxIndex bool) *Blockchain {
+	blockchain := &Blockchain{db: db}
+	err := db.Update(func(tx ChainTx) error {
+		if tx.GetTip() != nil {
+			return errors.New("store already holds a chain")
+		}
+		return blockchain.initChain(tx, genesisBlock, withTxIndex)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return blockchain
+}
+
+// initChain starts a chain in an empty store and connects the genesis block
+func (blockchain *Blockchain) initChain(tx ChainTx, genesisBlock *Block, withTxIndex bool) error {
+	if withTxIndex {
+		if err := tx.CreateTxIndex(); err != nil {
+			return err
+		}
+	}
+	if err := tx.PutSchemaVersion(currentSchemaVersion()); err != nil {
+		return err
+	}
+	if err := tx.PutBlock(genesisBlock); err != nil {
+		return err
+	}
+	return blockchain.setTip(tx, genesisBlock)
 }
 
 func NewBlockchain(nodeID string) *Blockchain {
@@ -191,46 +229,52 @@ func NewBlockchain(nodeID string) *Blockchain {
 		os.Exit(1)
 	}
 
-	var tip []byte
-	db, err := bolt.Open(dbFile, 0600, nil)
+	db, err := OpenBoltStore(dbFile)
 	if err != nil {
 		log.Panic(err)
 	}
-	err = db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(blocksBucketName))
-		tip = bucket.Get([]byte("l"))
+	if err := CheckSchemaVersion(db); err != nil {
+		db.Close()
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	return NewBlockchainFromStore(db)
+}
+
+// NewBlockchainFromStore loads the chain previously created in db, upgrading its schema if it was
+// written by an older version
+func NewBlockchainFromStore(db ChainStore) *Blockchain {
+	var tip []byte
+	err := db.View(func(tx ChainTx) error {
+		tip = tx.GetTip()
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
-	return &Blockchain{tip, db}
+
+	blockchain := &Blockchain{tip, db}
+	if err := blockchain.migrate(); err != nil {
+		log.Panic(err)
+	}
+	return blockchain
 }
 
 func (blockchain *Blockchain) AddBlock(block *Block) {
-	err := blockchain.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(blocksBucketName))
-		blockInDb := b.Get(block.Hash)
-		if blockInDb != nil {
+	err := blockchain.db.Update(func(tx ChainTx) error {
+		if tx.GetBlockSize(block.Hash) > 0 {
 			return nil
 		}
 
-		blockData := block.Serialize()
-		err := b.Put(block.Hash, blockData)
+		err := tx.PutBlock(block)
 		if err != nil {
 			log.Panic(err)
 		}
 
-		lastHash := b.Get([]byte("l"))
-		lastBlockData := b.Get(lastHash)
-		lastBlock := DeserializeBlock(lastBlockData)
+		lastBlock := tx.GetHeader(tx.GetTip())
 
 		if block.Height > lastBlock.Height {
-			err = b.Put([]byte("l"), block.Hash)
-			if err != nil {
-				log.Panic(err)
-			}
-			blockchain.tip = block.Hash
+			return blockchain.setTip(tx, block)
 		}
 
 		return nil
@@ -240,6 +284,95 @@ func (blockchain *Blockchain) AddBlock(block *Block) {
 	}
 }
 
+// setTip makes block the tip of the main chain. The blocks of the old branch back to the fork point
+// are disconnected and those of the new branch connected, so the chain indexes follow the switch.
+// If some blocks of the new branch haven't arrived yet (blocks are downloaded newest first) the tip
+// is still moved, and the indexes are left for a reindex once the download has finished.
+func (blockchain *Blockchain) setTip(tx ChainTx, block *Block) error {
+	complete := true
+	parent := func(b *Block) *Block {
+		if len(b.PrevBlockHash) == 0 {
+			return nil
+		}
+		prev := tx.GetBlock(b.PrevBlockHash)
+		if prev == nil {
+			complete = false
+		}
+		return prev
+	}
+
+	var disconnect, connect []*Block
+	newBranch := block
+	oldBranch := (*Block)(nil)
+	if lastHash := tx.GetTip(); lastHash != nil {
+		oldBranch = tx.GetBlock(lastHash)
+	}
+
+	// Walk both branches back to the fork point
+	for complete && oldBranch != nil && oldBranch.Height > newBranch.Height {
+		disconnect = append(disconnect, oldBranch)
+		oldBranch = parent(oldBranch)
+	}
+	for complete && newBranch != nil && (oldBranch == nil || newBranch.Height > oldBranch.Height) {
+		connect = append([]*Block{newBranch}, connect...)
+		newBranch = parent(newBranch)
+	}
+	for complete && oldBranch != nil && newBranch != nil && !bytes.Equal(oldBranch.Hash, newBranch.Hash) {
+		disconnect = append(disconnect, oldBranch)
+		connect = append([]*Block{newBranch}, connect...)
+		oldBranch = parent(oldBranch)
+		newBranch = parent(newBranch)
+	}
+
+	if !complete && tx.GetPruneState().PrunedHeight > 0 {
+		return errors.New("unable to switch to a branch that forks below the pruned height")
+	}
+	if complete {
+		for _, b := range disconnect {
+			if err := disconnectBlock(tx, b); err != nil {
+				return err
+			}
+		}
+		for _, b := range connect {
+			if err := connectBlock(tx, b); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := tx.PutTip(block.Hash); err != nil {
+		return err
+	}
+	blockchain.tip = block.Hash
+	return nil
+}
+
+// connectBlock updates the chain indexes for a block joining the main chain
+func connectBlock(tx ChainTx, block *Block) error {
+	if err := tx.PutHashAtHeight(block.Height, block.Hash); err != nil {
+		return err
+	}
+	if tx.HasTxIndex() {
+		if err := indexBlockTxs(tx, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// disconnectBlock updates the chain indexes for a block leaving the main chain
+func disconnectBlock(tx ChainTx, block *Block) error {
+	if err := tx.DeleteHashAtHeight(block.Height); err != nil {
+		return err
+	}
+	if tx.HasTxIndex() {
+		if err := unindexBlockTxs(tx, block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (blockchain *Blockchain) FindTxsWithUnspentOutputs(pubKeyHash []byte) []Transaction {
 	var txsWithUtxos []Transaction
 	spentTxos := make(map[string][]int) // txid -> []offset
@@ -248,6 +381,9 @@ func (blockchain *Blockchain) FindTxsWithUnspentOutputs(pubKeyHash []byte) []Tra
 	bci := blockchain.Iterator()
 	for {
 		block := bci.Next()
+		if block == nil {
+			break // the rest of the chain has been pruned
+		}
 
 		//Transactions
 		for _, tx := range block.Transactions {
@@ -286,38 +422,42 @@ func (blockchain *Blockchain) FindTxsWithUnspentOutputs(pubKeyHash []byte) []Tra
 	return txsWithUtxos
 }
 
-func (blockchain *Blockchain) BuildTransactionUtxoMap() map[string]TxOutputs {
-	spentTxos := make(map[string][]int) // txid -> []offset
-	utxoMap := make(map[string]TxOutputs)
+// BuildUtxoMap walks the main chain and returns every unspent output, keyed by hex encoded outpoint
+func (blockchain *Blockchain) BuildUtxoMap() map[string]UtxoEntry {
+	return blockchain.buildUtxoMapFrom(blockchain.tip)
+}
+
+// buildUtxoMapFrom returns the outputs that were unspent once the block blockHash was connected
+func (blockchain *Blockchain) buildUtxoMapFrom(blockHash []byte) map[string]UtxoEntry {
+	spent := make(map[string]bool) // outpoint -> spent
+	utxoMap := make(map[string]UtxoEntry)
 
 	// Blocks
-	bci := blockchain.Iterator()
+	bci := &BlockchainIterator{blockHash, blockchain.db}
 	for {
 		block := bci.Next()
+		if block == nil {
+			break // the rest of the chain has been pruned
+		}
 
 		//Transactions
 		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
 			// Transaction Outputs
 			for txoIndex, txo := range tx.Outputs {
-				spentOutputIndices := spentTxos[txID]
-				if slices.Contains(spentOutputIndices, txoIndex) {
+				outpoint := hex.EncodeToString(outpointKey(tx.ID, txoIndex))
+				if spent[outpoint] {
 					continue
 				}
 
 				// if here means there is a transaction output that isn't spent yet
-				utxoForTx := utxoMap[txID]
-				utxoForTx.Outputs = append(utxoForTx.Outputs, txo)
-				utxoMap[txID] = utxoForTx
+				utxoMap[outpoint] = NewUtxoEntry(txo, block.Height, tx.IsCoinbase())
 			}
 
 			// now inspect the inputs of the block to mark spent outputs
 			// coinbase can be ignored because they reference no inputs
 			if tx.IsCoinbase() == false {
 				for _, input := range tx.Inputs {
-					outputTxID := hex.EncodeToString(input.TxOutputID)
-					spentTxos[outputTxID] = append(spentTxos[outputTxID], input.TxOutputIndex)
+					spent[hex.EncodeToString(outpointKey(input.TxOutputID, input.TxOutputIndex))] = true
 				}
 			}
 		}
@@ -329,11 +469,19 @@ func (blockchain *Blockchain) BuildTransactionUtxoMap() map[string]TxOutputs {
 	return utxoMap
 }
 
-// FindTx iterates through all blocks to find the transaction with provided ID
+// FindTx finds the transaction with provided ID, using the transaction index if there is one
+// and otherwise iterating through all blocks
 func (blockchain *Blockchain) FindTx(ID []byte) (Transaction, error) {
+	if blockchain.HasTxIndex() {
+		return blockchain.findIndexedTx(ID)
+	}
+
 	bci := blockchain.Iterator()
 	for {
 		block := bci.Next()
+		if block == nil {
+			break // the rest of the chain has been pruned
+		}
 
 		for _, tx := range block.Transactions {
 			if bytes.Compare(tx.ID, ID) == 0 {
@@ -349,11 +497,21 @@ func (blockchain *Blockchain) FindTx(ID []byte) (Transaction, error) {
 	return Transaction{}, errors.New("transaction not found")
 }
 
+// findPrevTx finds a transaction referenced by an input. If its block has been pruned the
+// transaction's unspent outputs are recovered from the UTXO set instead.
+func (blockchain *Blockchain) findPrevTx(ID []byte) (Transaction, error) {
+	prevTx, err := blockchain.FindTx(ID)
+	if err != nil && blockchain.IsPruned() {
+		return UTXOSet{blockchain}.FindUnspentTx(ID)
+	}
+	return prevTx, err
+}
+
 // SignTransaction takes a transaction, finds all transactions it references and signs it
 func (blockchain *Blockchain) SignTransaction(tx *Transaction, key ecdsa.PrivateKey) {
 	prevTxs := make(map[string]Transaction)
 	for _, input := range tx.Inputs {
-		prevTx, err := blockchain.FindTx(input.TxOutputID)
+		prevTx, err := blockchain.findPrevTx(input.TxOutputID)
 		if err != nil {
 			log.Panic(err)
 		}
@@ -370,7 +528,7 @@ func (blockchain *Blockchain) VerifyTransaction(tx *Transaction) bool {
 
 	prevTxs := make(map[string]Transaction)
 	for _, input := range tx.Inputs {
-		prevTx, err := blockchain.FindTx(input.TxOutputID)
+		prevTx, err := blockchain.findPrevTx(input.TxOutputID)
 		if err != nil {
 			log.Panic(err)
 		}
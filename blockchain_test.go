@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+const testNodeID = "test"
+
+func newTestWallets(n int) (*Wallets, []string) {
+	wallets := &Wallets{WalletDatas: make(map[string]*WalletData)}
+	var addresses []string
+	for i := 0; i < n; i++ {
+		addresses = append(addresses, wallets.CreateWallet(""))
+	}
+	return wallets, addresses
+}
+
+// inTestDir runs the rest of the test in a fresh directory, where the chain and wallet files are written
+func inTestDir(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+}
+
+// newTestBlockchain creates a chain paying the genesis reward to address in a fresh directory
+func newTestBlockchain(t *testing.T, address string, withTxIndex bool) *Blockchain {
+	inTestDir(t)
+	bc := CreateBlockchain(address, testNodeID, withTxIndex)
+	t.Cleanup(func() { bc.db.Close() })
+	UTXOSet{bc}.Reindex()
+	return bc
+}
+
+func balanceOf(utxoSet UTXOSet, address string) int {
+	balance := 0
+	for _, output := range utxoSet.FindUtxos(ConvertBase58AddressToPubKeyHash(address)) {
+		balance = balance + output.Value
+	}
+	return balance
+}
+
+// mineTx mines tx into a new block along with a coinbase paying miner and updates the UTXO set
+func mineTx(bc *Blockchain, miner string, tx *Transaction) *Block {
+	coinbaseData := fmt.Sprintf("Block %d reward to: %s", bc.GetBestHeight()+1, miner) // keep coinbase IDs unique
+	return bc.MineBlock([]*Transaction{NewCoinbaseTx(miner, coinbaseData), tx})
+}
+
+// newTestBlock mines a block with just a coinbase paying miner on top of parent, without adding it to a chain
+func newTestBlock(miner string, parent *Block) *Block {
+	coinbaseData := fmt.Sprintf("Block %d on %x reward to: %s", parent.Height+1, parent.Hash, miner)
+	return NewBlock([]*Transaction{NewCoinbaseTx(miner, coinbaseData)}, parent.Hash, parent.Height+1)
+}
+
+func tipBlock(t *testing.T, bc *Blockchain) *Block {
+	block, err := bc.GetBlock(bc.tip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &block
+}
+
+func TestMineBlockRollsBackABlockItCantApply(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := newTestBlockchain(t, alice, false)
+	utxoSet := UTXOSet{bc}
+	tip := bc.tip
+
+	// Both transactions verify on their own, but the second spends what the first did
+	tx := NewUtxoTransaction(wallets, alice, []Payment{{bob, 3}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	doubleSpend := NewUtxoTransaction(wallets, alice, []Payment{{bob, 4}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	assert.Panics(t, func() { bc.MineBlock([]*Transaction{NewCoinbaseTx(alice, "block 1"), tx, doubleSpend}) })
+
+	assert.Equal(t, 0, bc.GetBestHeight(), "The block isn't stored")
+	assert.Equal(t, tip, bc.tip)
+	assert.Equal(t, 10, balanceOf(utxoSet, alice), "Nor applied to the UTXO set")
+	assert.Equal(t, 0, balanceOf(utxoSet, bob))
+}
+
+func TestSpendingOutputsInAnyOrder(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+
+	bc := CreateBlockchainInStore(alice, NewMemoryStore(), true)
+	utxoSet := UTXOSet{bc}
+	utxoSet.Reindex()
+
+	// Alice pays Bob twice in one transaction, so Bob owns outputs 0 and 1 of the same tx
+	payments := []Payment{{bob, 3}, {bob, 4}}
+	tx := NewUtxoTransaction(wallets, alice, payments, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	mineTx(bc, alice, tx)
+	assert.Equal(t, 7, balanceOf(utxoSet, bob))
+
+	// Spend output 1 on its own, then output 0, which used to shift down and be lost
+	_, found := utxoSet.GetUtxo(tx.ID, 1)
+	assert.True(t, found)
+	spend := NewUtxoTransaction(wallets, bob, []Payment{{alice, 4}}, &utxoSet, BranchAndBoundSelector{}, FeePolicy{})
+	assert.Equal(t, 1, spend.Inputs[0].TxOutputIndex, "Exact match spends output 1")
+	mineTx(bc, alice, spend)
+
+	entry, found := utxoSet.GetUtxo(tx.ID, 0)
+	assert.True(t, found, "Output 0 keeps its index after output 1 is spent")
+	assert.Equal(t, 3, entry.Value)
+
+	spend = NewUtxoTransaction(wallets, bob, []Payment{{alice, 3}}, &utxoSet, BranchAndBoundSelector{}, FeePolicy{})
+	mineTx(bc, alice, spend)
+	assert.Equal(t, 0, balanceOf(utxoSet, bob))
+
+	// The incrementally updated UTXO set matches a rebuilt one
+	aliceBalance := 0
+	for _, address := range wallets.GetAddresses() {
+		if address != bob {
+			aliceBalance = aliceBalance + balanceOf(utxoSet, address)
+		}
+	}
+	utxoSet.Reindex()
+	assert.Equal(t, 0, balanceOf(utxoSet, bob))
+	assert.Equal(t, 40, aliceBalance, "Every block reward ends up with Alice")
+}
+
+func TestChainIndexes(t *testing.T) {
+	_, addresses := newTestWallets(1)
+	bc := CreateBlockchainInStore(addresses[0], NewMemoryStore(), true)
+	UTXOSet{bc}.Reindex()
+
+	coinbase := NewCoinbaseTx(addresses[0], "block 1")
+	block := bc.MineBlock([]*Transaction{coinbase})
+
+	found, err := bc.FindTx(coinbase.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, coinbase.ID, found.ID, "Transaction is found through the index")
+
+	byHeight, err := bc.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, block.Hash, byHeight.Hash)
+	assert.Equal(t, [][]byte{block.Hash, block.PrevBlockHash}, bc.GetBlockHashes(), "Hashes run from tip to genesis")
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A block file holds main chain blocks in height order, for backing up or seeding a chain.
+// It starts with the magic bytes and a 4 byte big endian format version, then each block is
+// framed as:
+//
+//	record magic (4 bytes) | length (4 bytes, big endian) | checksum (4 bytes) | serialized block
+//
+// where the checksum is the first 4 bytes of the double sha256 of the serialized block.
+const blockFileMagic = "gcbf"
+const blockFileVersion = 2
+const blockRecordMagic = "blk:"
+const blockRecordHeaderLen = len(blockRecordMagic) + 8
+
+// maxBlockRecordLen guards against allocating a huge buffer for a corrupt length
+const maxBlockRecordLen = 32 * 1024 * 1024
+
+var errBlockFileTruncated = errors.New("block file ends part way through a block")
+
+func blockChecksum(data []byte) []byte {
+	first := sha256.Sum256(data)
+	second := sha256.Sum256(first[:])
+	return second[:4]
+}
+
+type BlockFileWriter struct {
+	writer *bufio.Writer
+}
+
+func NewBlockFileWriter(w io.Writer) (*BlockFileWriter, error) {
+	writer := bufio.NewWriter(w)
+	header := make([]byte, len(blockFileMagic)+4)
+	copy(header, blockFileMagic)
+	binary.BigEndian.PutUint32(header[len(blockFileMagic):], blockFileVersion)
+	if _, err := writer.Write(header); err != nil {
+		return nil, err
+	}
+	return &BlockFileWriter{writer}, nil
+}
+
+func (bfw *BlockFileWriter) WriteBlock(block *Block) error {
+	data := block.Serialize()
+	header := make([]byte, blockRecordHeaderLen)
+	copy(header, blockRecordMagic)
+	binary.BigEndian.PutUint32(header[len(blockRecordMagic):], uint32(len(data)))
+	copy(header[len(blockRecordMagic)+4:], blockChecksum(data))
+	if _, err := bfw.writer.Write(header); err != nil {
+		return err
+	}
+	_, err := bfw.writer.Write(data)
+	return err
+}
+
+func (bfw *BlockFileWriter) Flush() error {
+	return bfw.writer.Flush()
+}
+
+type BlockFileReader struct {
+	reader *bufio.Reader
+	count  int // blocks read so far
+}
+
+func NewBlockFileReader(r io.Reader) (*BlockFileReader, error) {
+	reader := bufio.NewReader(r)
+	header := make([]byte, len(blockFileMagic)+4)
+	if _, err := io.ReadFull(reader, header); err != nil || string(header[:len(blockFileMagic)]) != blockFileMagic {
+		return nil, errors.New("not a block file")
+	}
+	if version := binary.BigEndian.Uint32(header[len(blockFileMagic):]); version != blockFileVersion {
+		return nil, fmt.Errorf("unsupported block file version %d, this node reads version %d", version, blockFileVersion)
+	}
+	return &BlockFileReader{reader: reader}, nil
+}
+
+// ReadBlock returns the next block, or io.EOF once the file has been read to the end
+func (bfr *BlockFileReader) ReadBlock() (*Block, error) {
+	header := make([]byte, blockRecordHeaderLen)
+	if _, err := io.ReadFull(bfr.reader, header); err == io.EOF {
+		return nil, io.EOF
+	} else if err != nil {
+		return nil, errBlockFileTruncated
+	}
+	if string(header[:len(blockRecordMagic)]) != blockRecordMagic {
+		return nil, fmt.Errorf("block %d is corrupt, the record doesn't start with the record magic", bfr.count)
+	}
+	length := binary.BigEndian.Uint32(header[len(blockRecordMagic):])
+	if length > maxBlockRecordLen {
+		return nil, fmt.Errorf("block %d is corrupt, its length %d is too long", bfr.count, length)
+	}
+
+	data := make([]byte, length)
+	if _, err := io.ReadFull(bfr.reader, data); err != nil {
+		return nil, errBlockFileTruncated
+	}
+	if !bytes.Equal(blockChecksum(data), header[len(blockRecordMagic)+4:]) {
+		return nil, fmt.Errorf("block %d is corrupt, its checksum doesn't match", bfr.count)
+	}
+	bfr.count++
+	return DeserializeBlock(data), nil
+}
+
+// ExportChain writes every main chain block to w in height order, returning how many were written
+func (blockchain *Blockchain) ExportChain(w io.Writer) (int, error) {
+	if prunedHeight := blockchain.GetPruneState().PrunedHeight; prunedHeight > 0 {
+		return 0, fmt.Errorf("blocks below height %d have been pruned, unable to export the chain", prunedHeight)
+	}
+
+	bfw, err := NewBlockFileWriter(w)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	iterator := blockchain.IteratorFrom(0)
+	for block := iterator.Next(); block != nil; block = iterator.Next() {
+		if err := bfw.WriteBlock(block); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, bfw.Flush()
+}
+
+var errPrunedReorg = errors.New("the UTXO set of a pruned chain can't follow a reorganisation")
+
+// ImportBlock checks a block and connects it as if it had arrived from a peer, returning false if
+// the block was already known. A block that extends the tip is stored and applied to the UTXO
+// set in one update, so an import that is interrupted can always be resumed.
+func (blockchain *Blockchain) ImportBlock(block *Block) (bool, error) {
+	if blockchain.HasBlock(block.Hash) {
+		return false, nil
+	}
+	if err := blockchain.CheckBlock(block); err != nil {
+		return false, err
+	}
+
+	reorganised := false
+	tip := blockchain.tip
+	err := blockchain.db.Update(func(tx ChainTx) error {
+		lastHash := tx.GetTip()
+		lastHeight := tx.GetHeader(lastHash).Height
+		if err := tx.PutBlock(block); err != nil {
+			return err
+		}
+		if block.Height <= lastHeight {
+			return nil
+		}
+		if !bytes.Equal(block.PrevBlockHash, lastHash) {
+			if tx.GetPruneState().PrunedHeight > 0 {
+				return errPrunedReorg // rolls back, so the tip stays on the chain the UTXO set follows
+			}
+			reorganised = true
+		}
+		if err := blockchain.setTip(tx, block); err != nil {
+			return err
+		}
+		if reorganised {
+			return nil
+		}
+		return updateUtxos(tx, block)
+	})
+	if err != nil {
+		blockchain.tip = tip // the update was rolled back
+		return false, err
+	}
+
+	if reorganised {
+		UTXOSet{blockchain}.Reindex()
+	}
+	return true, nil
+}
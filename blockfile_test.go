@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestExportImportChain(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := CreateBlockchainInStore(alice, NewMemoryStore(), true)
+	utxoSet := UTXOSet{bc}
+	utxoSet.Reindex()
+	tx := NewUtxoTransaction(wallets, alice, []Payment{{bob, 4}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	mineTx(bc, alice, tx)
+
+	var buffer bytes.Buffer
+	count, err := bc.ExportChain(&buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count)
+	data := buffer.Bytes()
+
+	reader, err := NewBlockFileReader(bytes.NewReader(data))
+	assert.Nil(t, err)
+	genesis, err := reader.ReadBlock()
+	assert.Nil(t, err)
+	imported := CreateBlockchainFromGenesis(genesis, NewMemoryStore(), true)
+	UTXOSet{imported}.Reindex()
+	block, err := reader.ReadBlock()
+	assert.Nil(t, err)
+	added, err := imported.ImportBlock(block)
+	assert.Nil(t, err)
+	assert.True(t, added)
+	added, err = imported.ImportBlock(block)
+	assert.Nil(t, err)
+	assert.False(t, added, "Known blocks are skipped")
+	_, err = reader.ReadBlock()
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 4, balanceOf(UTXOSet{imported}, bob))
+
+	block.Transactions[0].Outputs[0].Value = 1000
+	assert.NotNil(t, imported.CheckBlock(block), "Changing a block breaks its proof of work")
+
+	data[len(data)-1] ^= 0xff
+	reader, _ = NewBlockFileReader(bytes.NewReader(data))
+	reader.ReadBlock()
+	_, err = reader.ReadBlock()
+	assert.ErrorContains(t, err, "checksum")
+}
+
+func TestImportBlockRefusesAReorgOnAPrunedChain(t *testing.T) {
+	defer func(keep int) { minBlocksToKeep = keep }(minBlocksToKeep)
+	minBlocksToKeep = 3
+
+	_, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := CreateBlockchainInStore(alice, NewMemoryStore(), false)
+	UTXOSet{bc}.Reindex()
+	for height := 1; height <= 4; height++ {
+		bc.MineBlock([]*Transaction{NewCoinbaseTx(alice, fmt.Sprintf("block %d", height))})
+	}
+	assert.Nil(t, bc.SetPruneTarget(minBlocksToKeep, 0))
+	_, err := bc.Prune()
+	assert.Nil(t, err)
+	tip := bc.tip
+
+	// Bob mines a branch off height 3 that overtakes the chain at height 5
+	forkPoint, err := bc.GetBlockByHeight(3)
+	assert.Nil(t, err)
+	sideBlock := newTestBlock(bob, &forkPoint)
+	added, err := bc.ImportBlock(sideBlock)
+	assert.Nil(t, err)
+	assert.True(t, added, "A branch that isn't longer is just stored")
+	reorgBlock := newTestBlock(bob, sideBlock)
+	added, err = bc.ImportBlock(reorgBlock)
+	assert.ErrorIs(t, err, errPrunedReorg)
+	assert.False(t, added)
+
+	assert.Equal(t, tip, bc.tip)
+	assert.Equal(t, 4, bc.GetBestHeight())
+	assert.False(t, bc.HasBlock(reorgBlock.Hash), "The block isn't stored")
+	assert.Equal(t, 0, balanceOf(UTXOSet{bc}, bob), "The UTXO set still follows the tip")
+	checked, err := bc.VerifyChain(0, maxVerifyLevel)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, checked, "Heights 2 to 4 are kept")
+}
@@ -0,0 +1,12 @@
+package main
+
+// ChainParams holds values that are fixed for the chain and compiled into the node
+type ChainParams struct {
+	// AssumeUtxo maps a block height to the hex hash of the UTXO snapshot taken at that height,
+	// so a snapshot fetched from anyone can be checked before a new node bootstraps from it
+	AssumeUtxo map[int]string
+}
+
+var chainParams = ChainParams{
+	AssumeUtxo: map[int]string{},
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"github.com/boltdb/bolt"
+)
+
+// ChainStore is where a Blockchain keeps everything it persists: blocks and their headers, the
+// chain tip, the height and transaction indexes, the chainstate (the UTXO set and its address
+// indexes) and the chain metadata. Every read happens inside View and every write inside
+// Update; the writes made by one Update are applied atomically (all or nothing if fn returns an
+// error or panics), so an Update is also how related writes are batched.
+type ChainStore interface {
+	View(fn func(tx ChainTx) error) error
+	Update(fn func(tx ChainTx) error) error
+	Close() error
+}
+
+// ChainTx reads and, inside Update, writes the chain. Getters return nil (or false) for
+// anything that isn't stored. Byte slices handed out stay valid after the View or Update returns.
+type ChainTx interface {
+	// GetBlock returns a block whose body is stored, so not one that is unknown or pruned
+	GetBlock(hash []byte) *Block
+	// GetBlockSize returns the size of a stored block body, or 0
+	GetBlockSize(hash []byte) int
+	// GetHeader returns the header of any known block, including pruned ones
+	GetHeader(hash []byte) *BlockHeader
+	// PutBlock stores a block along with its header
+	PutBlock(block *Block) error
+	PutHeader(header *BlockHeader) error
+	// DeleteBlock deletes the body of a block, keeping its header
+	DeleteBlock(hash []byte) error
+	// ForEachBlock calls fn with every stored block, in no particular order
+	ForEachBlock(fn func(block *Block) error) error
+
+	// GetTip returns the hash of the tip of the main chain, or nil if the store holds no chain
+	GetTip() []byte
+	PutTip(hash []byte) error
+
+	// The height index maps the height of every main chain block to its hash
+	GetHashAtHeight(height int) []byte
+	// GetHashesInRange returns the hashes at heights from up to and including to, in height order
+	GetHashesInRange(from, to int) [][]byte
+	PutHashAtHeight(height int, hash []byte) error
+	DeleteHashAtHeight(height int) error
+	DeleteHeights() error
+
+	// The transaction index is optional, it maps the ID of every main chain transaction to where it is
+	HasTxIndex() bool
+	CreateTxIndex() error
+	DropTxIndex() error
+	GetTxLocation(txID []byte) *TxLocation
+	PutTxLocation(txID []byte, location TxLocation) error
+	DeleteTxLocation(txID []byte) error
+
+	// The chainstate holds one entry per unspent output, by outpoint (see outpointKey). Putting
+	// and deleting entries keeps the index of unspent outputs by address up to date.
+	GetUtxo(outpoint []byte) (UtxoEntry, bool)
+	PutUtxo(outpoint []byte, entry UtxoEntry) error
+	DeleteUtxo(outpoint []byte) error
+	// ForEachUtxo calls fn with every unspent output in outpoint order
+	ForEachUtxo(fn func(outpoint []byte, entry UtxoEntry) error) error
+	// ForEachUtxoOfTx calls fn with the unspent outputs of one transaction
+	ForEachUtxoOfTx(txID []byte, fn func(outpoint []byte, entry UtxoEntry) error) error
+	// ForEachUtxoOf calls fn with the unspent outputs locked with pubKeyHash
+	ForEachUtxoOf(pubKeyHash []byte, fn func(outpoint []byte, entry UtxoEntry) error) error
+
+	// The address history records every main chain transaction that paid to or spent from an address
+	PutHistory(pubKeyHash []byte, height int, txID []byte) error
+	// GetHistory returns the history of pubKeyHash, oldest first
+	GetHistory(pubKeyHash []byte) []AddressHistoryEntry
+	// DeleteChainstate empties the chainstate, its address index and the address history
+	DeleteChainstate() error
+
+	GetSchemaVersion() int
+	PutSchemaVersion(version int) error
+	GetPruneState() PruneState
+	PutPruneState(state PruneState) error
+}
+
+// BackupStore is implemented by stores that can copy themselves, so risky changes like
+// migrations can be undone. Backup writes the copy alongside the store, named with suffix.
+type BackupStore interface {
+	Backup(suffix string) (path string, err error)
+}
+
+// boltStore keeps the chain in a bolt database file, laid out as in chainstore_kv.go
+type boltStore struct {
+	kvChainStore
+	db *bolt.DB
+}
+
+// boltKV is the ordered key-value store of a bolt database
+type boltKV struct {
+	db *bolt.DB
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+type boltBucket struct {
+	*bolt.Bucket
+}
+
+func OpenBoltStore(path string) (ChainStore, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &boltStore{kvChainStore{boltKV{db}}, db}, nil
+}
+
+func (store *boltStore) Backup(suffix string) (string, error) {
+	path := store.db.Path() + suffix
+	err := store.db.View(func(tx *bolt.Tx) error {
+		return tx.CopyFile(path, 0600)
+	})
+	return path, err
+}
+
+func (kv boltKV) View(fn func(tx KVTx) error) error {
+	return kv.db.View(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (kv boltKV) Update(fn func(tx KVTx) error) error {
+	return kv.db.Update(func(tx *bolt.Tx) error {
+		return fn(boltTx{tx})
+	})
+}
+
+func (kv boltKV) Close() error {
+	return kv.db.Close()
+}
+
+func (tx boltTx) Bucket(name []byte) KVBucket {
+	bucket := tx.tx.Bucket(name)
+	if bucket == nil {
+		return nil
+	}
+	return boltBucket{bucket}
+}
+
+func (tx boltTx) CreateBucket(name []byte) (KVBucket, error) {
+	bucket, err := tx.tx.CreateBucket(name)
+	if err != nil {
+		return nil, err
+	}
+	return boltBucket{bucket}, nil
+}
+
+func (tx boltTx) DeleteBucket(name []byte) error {
+	return tx.tx.DeleteBucket(name)
+}
+
+func (bucket boltBucket) Cursor() KVCursor {
+	return bucket.Bucket.Cursor()
+}
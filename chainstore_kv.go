@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+)
+
+// KVStore is an ordered key-value store of named buckets, like bolt. kvChainStore lays a chain
+// out in one, so the bolt and memory stores share a layout:
+//
+//	blocks      block hash -> serialized block (and "l" -> hash of the chain tip)
+//	headers     block hash -> serialized block header (kept when the block is pruned)
+//	heights     height -> hash of the main chain block at that height
+//	txindex     tx ID -> location of the transaction on the main chain (only if enabled)
+//	chainstate  outpoint -> unspent output
+//	addrutxo    pubkey hash + outpoint -> nothing (the outpoint is unspent and locked to the address)
+//	addrhistory pubkey hash + height + tx ID -> nothing (the tx paid to or spent from the address)
+//	prune       "state" -> pruning configuration and progress
+//	metadata    "schemaversion" -> version of this layout (see schema.go)
+//
+// Heights are big endian so cursors walk them in order, and a pubkey hash is always 20 bytes
+// (RIPEMD160) so it can be used directly as a key prefix. Keys and values handed out by a KVTx
+// are only valid until the View or Update returns.
+type KVStore interface {
+	View(fn func(tx KVTx) error) error
+	Update(fn func(tx KVTx) error) error
+	Close() error
+}
+
+type KVTx interface {
+	// Bucket returns the named bucket, or nil if it doesn't exist
+	Bucket(name []byte) KVBucket
+	CreateBucket(name []byte) (KVBucket, error)
+	DeleteBucket(name []byte) error
+}
+
+type KVBucket interface {
+	Get(key []byte) []byte
+	Put(key, value []byte) error
+	Delete(key []byte) error
+	Cursor() KVCursor
+}
+
+// KVCursor walks a bucket in key order. Each method returns a nil key once the cursor runs off the end.
+type KVCursor interface {
+	First() (key, value []byte)
+	Last() (key, value []byte)
+	Next() (key, value []byte)
+	Prev() (key, value []byte)
+	Seek(seek []byte) (key, value []byte)
+}
+
+const blocksBucketName = "blocks"
+const tipKey = "l"
+const headersBucketName = "headers"
+const heightIndexBucketName = "heights"
+const txIndexBucketName = "txindex"
+const utxoBucketName = "chainstate"
+const addressUtxoBucketName = "addrutxo"
+const addressHistoryBucketName = "addrhistory"
+const pruneBucketName = "prune"
+const pruneStateKey = "state"
+const metadataBucketName = "metadata"
+const schemaVersionKey = "schemaversion"
+
+var errTxIndexDisabled = errors.New("the transaction index is not enabled")
+
+// kvChainStore keeps a chain in a KVStore
+type kvChainStore struct {
+	kv KVStore
+}
+
+type kvChainTx struct {
+	tx KVTx
+}
+
+func NewKVChainStore(kv KVStore) ChainStore {
+	return kvChainStore{kv}
+}
+
+func (store kvChainStore) View(fn func(tx ChainTx) error) error {
+	return store.kv.View(func(tx KVTx) error {
+		return fn(kvChainTx{tx})
+	})
+}
+
+func (store kvChainStore) Update(fn func(tx ChainTx) error) error {
+	return store.kv.Update(func(tx KVTx) error {
+		return fn(kvChainTx{tx})
+	})
+}
+
+func (store kvChainStore) Close() error {
+	return store.kv.Close()
+}
+
+func heightKey(height int) []byte {
+	return Int64ToBytes(int64(height))
+}
+
+func addressUtxoKey(pubKeyHash, outpoint []byte) []byte {
+	return bytes.Join([][]byte{pubKeyHash, outpoint}, []byte{})
+}
+
+func addressHistoryKey(pubKeyHash []byte, height int, txID []byte) []byte {
+	return bytes.Join([][]byte{pubKeyHash, heightKey(height), txID}, []byte{})
+}
+
+// get returns a copy of the value of key in the named bucket, or nil
+func (tx kvChainTx) get(bucketName string, key []byte) []byte {
+	bucket := tx.tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return nil
+	}
+	value := bucket.Get(key)
+	if value == nil {
+		return nil
+	}
+	return append([]byte{}, value...)
+}
+
+// put sets key in the named bucket, creating the bucket if it doesn't exist yet
+func (tx kvChainTx) put(bucketName string, key, value []byte) error {
+	bucket := tx.tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		var err error
+		if bucket, err = tx.tx.CreateBucket([]byte(bucketName)); err != nil {
+			return err
+		}
+	}
+	return bucket.Put(key, value)
+}
+
+func (tx kvChainTx) delete(bucketName string, key []byte) error {
+	bucket := tx.tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return nil
+	}
+	return bucket.Delete(key)
+}
+
+func (tx kvChainTx) deleteBucket(bucketName string) error {
+	if tx.tx.Bucket([]byte(bucketName)) == nil {
+		return nil
+	}
+	return tx.tx.DeleteBucket([]byte(bucketName))
+}
+
+// forEachWithPrefix calls fn with every key in the named bucket starting with prefix, in order
+func (tx kvChainTx) forEachWithPrefix(bucketName string, prefix []byte, fn func(key, value []byte) error) error {
+	bucket := tx.tx.Bucket([]byte(bucketName))
+	if bucket == nil {
+		return nil
+	}
+	cursor := bucket.Cursor()
+	for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+		if err := fn(append([]byte{}, key...), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx kvChainTx) GetBlock(hash []byte) *Block {
+	data := tx.get(blocksBucketName, hash)
+	if data == nil {
+		return nil
+	}
+	return DeserializeBlock(data)
+}
+
+func (tx kvChainTx) GetBlockSize(hash []byte) int {
+	return len(tx.get(blocksBucketName, hash))
+}
+
+func (tx kvChainTx) GetHeader(hash []byte) *BlockHeader {
+	data := tx.get(headersBucketName, hash)
+	if data == nil {
+		return nil
+	}
+	return DeserializeBlockHeader(data)
+}
+
+func (tx kvChainTx) PutBlock(block *Block) error {
+	if err := tx.put(blocksBucketName, block.Hash, block.Serialize()); err != nil {
+		return err
+	}
+	header := block.Header()
+	return tx.PutHeader(&header)
+}
+
+func (tx kvChainTx) PutHeader(header *BlockHeader) error {
+	return tx.put(headersBucketName, header.Hash, header.Serialize())
+}
+
+func (tx kvChainTx) DeleteBlock(hash []byte) error {
+	return tx.delete(blocksBucketName, hash)
+}
+
+func (tx kvChainTx) ForEachBlock(fn func(block *Block) error) error {
+	return tx.forEachWithPrefix(blocksBucketName, nil, func(key, value []byte) error {
+		if string(key) == tipKey {
+			return nil
+		}
+		return fn(DeserializeBlock(value))
+	})
+}
+
+func (tx kvChainTx) GetTip() []byte {
+	return tx.get(blocksBucketName, []byte(tipKey))
+}
+
+func (tx kvChainTx) PutTip(hash []byte) error {
+	return tx.put(blocksBucketName, []byte(tipKey), hash)
+}
+
+func (tx kvChainTx) GetHashAtHeight(height int) []byte {
+	return tx.get(heightIndexBucketName, heightKey(height))
+}
+
+func (tx kvChainTx) GetHashesInRange(from, to int) [][]byte {
+	var hashes [][]byte
+	bucket := tx.tx.Bucket([]byte(heightIndexBucketName))
+	if bucket == nil || to < from {
+		return hashes
+	}
+	if from < 0 {
+		from = 0
+	}
+	cursor := bucket.Cursor()
+	for key, value := cursor.Seek(heightKey(from)); key != nil && int(BytesToInt64(key)) <= to; key, value = cursor.Next() {
+		hashes = append(hashes, append([]byte{}, value...))
+	}
+	return hashes
+}
+
+func (tx kvChainTx) PutHashAtHeight(height int, hash []byte) error {
+	return tx.put(heightIndexBucketName, heightKey(height), hash)
+}
+
+func (tx kvChainTx) DeleteHashAtHeight(height int) error {
+	return tx.delete(heightIndexBucketName, heightKey(height))
+}
+
+func (tx kvChainTx) DeleteHeights() error {
+	return tx.deleteBucket(heightIndexBucketName)
+}
+
+func (tx kvChainTx) HasTxIndex() bool {
+	return tx.tx.Bucket([]byte(txIndexBucketName)) != nil
+}
+
+func (tx kvChainTx) CreateTxIndex() error {
+	if tx.HasTxIndex() {
+		return nil
+	}
+	_, err := tx.tx.CreateBucket([]byte(txIndexBucketName))
+	return err
+}
+
+func (tx kvChainTx) DropTxIndex() error {
+	return tx.deleteBucket(txIndexBucketName)
+}
+
+func (tx kvChainTx) GetTxLocation(txID []byte) *TxLocation {
+	data := tx.get(txIndexBucketName, txID)
+	if data == nil {
+		return nil
+	}
+	location := DeserializeTxLocation(data)
+	return &location
+}
+
+func (tx kvChainTx) PutTxLocation(txID []byte, location TxLocation) error {
+	if !tx.HasTxIndex() {
+		return errTxIndexDisabled
+	}
+	return tx.put(txIndexBucketName, txID, location.Serialize())
+}
+
+func (tx kvChainTx) DeleteTxLocation(txID []byte) error {
+	return tx.delete(txIndexBucketName, txID)
+}
+
+func (tx kvChainTx) GetUtxo(outpoint []byte) (UtxoEntry, bool) {
+	data := tx.get(utxoBucketName, outpoint)
+	if data == nil {
+		return UtxoEntry{}, false
+	}
+	return DeserializeUtxoEntry(data), true
+}
+
+func (tx kvChainTx) PutUtxo(outpoint []byte, entry UtxoEntry) error {
+	if err := tx.put(utxoBucketName, outpoint, entry.Serialize()); err != nil {
+		return err
+	}
+	return tx.put(addressUtxoBucketName, addressUtxoKey(entry.PubKeyHash, outpoint), []byte{})
+}
+
+func (tx kvChainTx) DeleteUtxo(outpoint []byte) error {
+	entry, found := tx.GetUtxo(outpoint)
+	if !found {
+		return nil
+	}
+	if err := tx.delete(utxoBucketName, outpoint); err != nil {
+		return err
+	}
+	return tx.delete(addressUtxoBucketName, addressUtxoKey(entry.PubKeyHash, outpoint))
+}
+
+func (tx kvChainTx) ForEachUtxo(fn func(outpoint []byte, entry UtxoEntry) error) error {
+	return tx.ForEachUtxoOfTx(nil, fn)
+}
+
+func (tx kvChainTx) ForEachUtxoOfTx(txID []byte, fn func(outpoint []byte, entry UtxoEntry) error) error {
+	return tx.forEachWithPrefix(utxoBucketName, txID, func(key, value []byte) error {
+		return fn(key, DeserializeUtxoEntry(value))
+	})
+}
+
+func (tx kvChainTx) ForEachUtxoOf(pubKeyHash []byte, fn func(outpoint []byte, entry UtxoEntry) error) error {
+	return tx.forEachWithPrefix(addressUtxoBucketName, pubKeyHash, func(key, _ []byte) error {
+		outpoint := key[len(pubKeyHash):]
+		if entry, found := tx.GetUtxo(outpoint); found {
+			return fn(outpoint, entry)
+		}
+		return nil
+	})
+}
+
+func (tx kvChainTx) PutHistory(pubKeyHash []byte, height int, txID []byte) error {
+	return tx.put(addressHistoryBucketName, addressHistoryKey(pubKeyHash, height, txID), []byte{})
+}
+
+func (tx kvChainTx) GetHistory(pubKeyHash []byte) []AddressHistoryEntry {
+	var history []AddressHistoryEntry
+	tx.forEachWithPrefix(addressHistoryBucketName, pubKeyHash, func(key, _ []byte) error {
+		rest := key[len(pubKeyHash):]
+		history = append(history, AddressHistoryEntry{
+			Height: int(BytesToInt64(rest[:8])),
+			TxID:   rest[8:],
+		})
+		return nil
+	})
+	return history
+}
+
+func (tx kvChainTx) DeleteChainstate() error {
+	for _, bucketName := range []string{utxoBucketName, addressUtxoBucketName, addressHistoryBucketName} {
+		if err := tx.deleteBucket(bucketName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (tx kvChainTx) GetSchemaVersion() int {
+	data := tx.get(metadataBucketName, []byte(schemaVersionKey))
+	if data == nil {
+		return 0
+	}
+	return int(BytesToInt64(data))
+}
+
+func (tx kvChainTx) PutSchemaVersion(version int) error {
+	return tx.put(metadataBucketName, []byte(schemaVersionKey), Int64ToBytes(int64(version)))
+}
+
+func (tx kvChainTx) GetPruneState() PruneState {
+	data := tx.get(pruneBucketName, []byte(pruneStateKey))
+	if data == nil {
+		return PruneState{}
+	}
+	return DeserializePruneState(data)
+}
+
+func (tx kvChainTx) PutPruneState(state PruneState) error {
+	return tx.put(pruneBucketName, []byte(pruneStateKey), state.Serialize())
+}
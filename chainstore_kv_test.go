@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestKVChainStore(t *testing.T) {
+	_, addresses := newTestWallets(1)
+	pubKeyHash := ConvertBase58AddressToPubKeyHash(addresses[0])
+	block := NewGenesisBlock(NewCoinbaseTx(addresses[0], "genesis"))
+	outpoint := outpointKey(block.Transactions[0].ID, 0)
+	store := NewMemoryStore()
+
+	err := store.Update(func(tx ChainTx) error {
+		if err := tx.PutBlock(block); err != nil {
+			return err
+		}
+		assert.ErrorIs(t, tx.PutTxLocation(block.Transactions[0].ID, TxLocation{block.Hash, 0}), errTxIndexDisabled)
+		return tx.PutUtxo(outpoint, NewUtxoEntry(block.Transactions[0].Outputs[0], 0, true))
+	})
+	assert.Nil(t, err)
+
+	store.View(func(tx ChainTx) error {
+		assert.Nil(t, tx.GetTip(), "Storing a block doesn't make it the tip")
+		assert.Equal(t, block.Hash, tx.GetHeader(block.Hash).Hash)
+		var found [][]byte
+		tx.ForEachUtxoOf(pubKeyHash, func(key []byte, _ UtxoEntry) error {
+			found = append(found, key)
+			return nil
+		})
+		assert.Equal(t, [][]byte{outpoint}, found, "Unspent outputs are indexed by address")
+		return nil
+	})
+
+	err = store.Update(func(tx ChainTx) error {
+		if err := tx.DeleteBlock(block.Hash); err != nil {
+			return err
+		}
+		return tx.DeleteUtxo(outpoint)
+	})
+	assert.Nil(t, err)
+
+	store.View(func(tx ChainTx) error {
+		assert.Nil(t, tx.GetBlock(block.Hash))
+		assert.NotNil(t, tx.GetHeader(block.Hash), "Headers outlive pruned blocks")
+		tx.ForEachUtxoOf(pubKeyHash, func(key []byte, _ UtxoEntry) error {
+			assert.Fail(t, "Spent outputs are unindexed")
+			return nil
+		})
+		return nil
+	})
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+	"sync"
+)
+
+var errBucketExists = errors.New("bucket already exists")
+var errBucketNotFound = errors.New("bucket not found")
+var errStoreReadOnly = errors.New("store is read only inside View")
+var errStoreClosed = errors.New("store is closed")
+
+// memoryKV is a KVStore in memory, for tests and simulations that shouldn't touch disk. Buckets
+// are copied on write: an Update works on its own copy of each bucket it changes and swaps them
+// all in once fn has succeeded. Like bolt, a View only ever sees committed data, even while an
+// Update is running, and an Update that fails or panics leaves nothing behind.
+type memoryKV struct {
+	writer  sync.Mutex   // held for the whole of an Update
+	mutex   sync.RWMutex // guards buckets and closed
+	buckets map[string]map[string][]byte
+	closed  bool
+}
+
+// memoryTx sees the buckets as they were when it started. Committed bucket maps are never
+// modified, so a writable memoryTx copies a bucket before its first change to it.
+type memoryTx struct {
+	buckets  map[string]map[string][]byte
+	copied   map[string]bool
+	writable bool
+}
+
+type memoryBucket struct {
+	tx   *memoryTx
+	name string
+}
+
+// memoryCursor iterates over a snapshot of the bucket's keys taken when the cursor was created
+type memoryCursor struct {
+	bucket *memoryBucket
+	keys   []string
+	index  int
+}
+
+func NewMemoryKV() KVStore {
+	return &memoryKV{buckets: make(map[string]map[string][]byte)}
+}
+
+// NewMemoryStore returns an empty ChainStore kept in memory
+func NewMemoryStore() ChainStore {
+	return NewKVChainStore(NewMemoryKV())
+}
+
+func (store *memoryKV) committed() (map[string]map[string][]byte, error) {
+	store.mutex.RLock()
+	defer store.mutex.RUnlock()
+	if store.closed {
+		return nil, errStoreClosed
+	}
+	return store.buckets, nil
+}
+
+func (store *memoryKV) View(fn func(tx KVTx) error) error {
+	buckets, err := store.committed()
+	if err != nil {
+		return err
+	}
+	return fn(&memoryTx{buckets: buckets})
+}
+
+func (store *memoryKV) Update(fn func(tx KVTx) error) error {
+	store.writer.Lock()
+	defer store.writer.Unlock()
+	buckets, err := store.committed()
+	if err != nil {
+		return err
+	}
+
+	tx := &memoryTx{buckets: make(map[string]map[string][]byte), copied: make(map[string]bool), writable: true}
+	for name, values := range buckets {
+		tx.buckets[name] = values
+	}
+	// Nothing is committed until fn returns, so an error or a panic unwinding past here leaves
+	// the store as it was
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.buckets = tx.buckets
+	return nil
+}
+
+func (store *memoryKV) Close() error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.closed = true
+	return nil
+}
+
+func (tx *memoryTx) Bucket(name []byte) KVBucket {
+	if _, ok := tx.buckets[string(name)]; !ok {
+		return nil
+	}
+	return &memoryBucket{tx, string(name)}
+}
+
+func (tx *memoryTx) CreateBucket(name []byte) (KVBucket, error) {
+	if !tx.writable {
+		return nil, errStoreReadOnly
+	}
+	key := string(name)
+	if _, ok := tx.buckets[key]; ok {
+		return nil, errBucketExists
+	}
+	tx.buckets[key] = make(map[string][]byte)
+	tx.copied[key] = true
+	return &memoryBucket{tx, key}, nil
+}
+
+func (tx *memoryTx) DeleteBucket(name []byte) error {
+	if !tx.writable {
+		return errStoreReadOnly
+	}
+	key := string(name)
+	if _, ok := tx.buckets[key]; !ok {
+		return errBucketNotFound
+	}
+	delete(tx.buckets, key)
+	delete(tx.copied, key)
+	return nil
+}
+
+// values returns the bucket's map for reading
+func (bucket *memoryBucket) values() map[string][]byte {
+	return bucket.tx.buckets[bucket.name]
+}
+
+// writableValues returns the bucket's map for writing, copying it first if it is still the committed one
+func (bucket *memoryBucket) writableValues() (map[string][]byte, error) {
+	tx := bucket.tx
+	if !tx.writable {
+		return nil, errStoreReadOnly
+	}
+	values, ok := tx.buckets[bucket.name]
+	if !ok {
+		return nil, errBucketNotFound
+	}
+	if !tx.copied[bucket.name] {
+		copied := make(map[string][]byte, len(values))
+		for key, value := range values {
+			copied[key] = value
+		}
+		values = copied
+		tx.buckets[bucket.name] = values
+		tx.copied[bucket.name] = true
+	}
+	return values, nil
+}
+
+func (bucket *memoryBucket) Get(key []byte) []byte {
+	return bucket.values()[string(key)]
+}
+
+func (bucket *memoryBucket) Put(key, value []byte) error {
+	values, err := bucket.writableValues()
+	if err != nil {
+		return err
+	}
+	values[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (bucket *memoryBucket) Delete(key []byte) error {
+	values, err := bucket.writableValues()
+	if err != nil {
+		return err
+	}
+	delete(values, string(key))
+	return nil
+}
+
+func (bucket *memoryBucket) Cursor() KVCursor {
+	values := bucket.values()
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return &memoryCursor{bucket: bucket, keys: keys}
+}
+
+// current returns the entry under the cursor, skipping keys deleted since the snapshot was taken
+func (cursor *memoryCursor) current(step int) ([]byte, []byte) {
+	values := cursor.bucket.values()
+	for cursor.index >= 0 && cursor.index < len(cursor.keys) {
+		key := cursor.keys[cursor.index]
+		if value, ok := values[key]; ok {
+			return []byte(key), value
+		}
+		cursor.index += step
+	}
+	return nil, nil
+}
+
+func (cursor *memoryCursor) First() ([]byte, []byte) {
+	cursor.index = 0
+	return cursor.current(1)
+}
+
+func (cursor *memoryCursor) Last() ([]byte, []byte) {
+	cursor.index = len(cursor.keys) - 1
+	return cursor.current(-1)
+}
+
+func (cursor *memoryCursor) Next() ([]byte, []byte) {
+	cursor.index++
+	return cursor.current(1)
+}
+
+func (cursor *memoryCursor) Prev() ([]byte, []byte) {
+	cursor.index--
+	return cursor.current(-1)
+}
+
+func (cursor *memoryCursor) Seek(seek []byte) ([]byte, []byte) {
+	cursor.index = sort.Search(len(cursor.keys), func(i int) bool {
+		return bytes.Compare([]byte(cursor.keys[i]), seek) >= 0
+	})
+	return cursor.current(1)
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestMemoryStoreUpdateIsAtomic(t *testing.T) {
+	store := NewMemoryKV()
+	err := store.Update(func(tx KVTx) error {
+		bucket, err := tx.CreateBucket([]byte("b"))
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte("k"), []byte("v1"))
+	})
+	assert.Nil(t, err)
+
+	failed := errors.New("failed")
+	err = store.Update(func(tx KVTx) error {
+		bucket := tx.Bucket([]byte("b"))
+		bucket.Put([]byte("k"), []byte("v2"))
+		bucket.Put([]byte("other"), []byte("v3"))
+		tx.CreateBucket([]byte("c"))
+		return failed
+	})
+	assert.Equal(t, failed, err)
+
+	store.View(func(tx KVTx) error {
+		assert.Equal(t, []byte("v1"), tx.Bucket([]byte("b")).Get([]byte("k")), "Overwritten value is restored")
+		assert.Nil(t, tx.Bucket([]byte("b")).Get([]byte("other")), "New key is removed")
+		assert.Nil(t, tx.Bucket([]byte("c")), "New bucket is removed")
+		return nil
+	})
+}
+
+func TestMemoryStoreCursor(t *testing.T) {
+	store := NewMemoryKV()
+	store.Update(func(tx KVTx) error {
+		bucket, _ := tx.CreateBucket([]byte("b"))
+		for _, key := range []string{"c", "a", "e", "b"} {
+			bucket.Put([]byte(key), []byte(key))
+		}
+		return nil
+	})
+
+	store.View(func(tx KVTx) error {
+		var keys []string
+		cursor := tx.Bucket([]byte("b")).Cursor()
+		for key, _ := cursor.First(); key != nil; key, _ = cursor.Next() {
+			keys = append(keys, string(key))
+		}
+		assert.Equal(t, []string{"a", "b", "c", "e"}, keys, "Keys are walked in order")
+
+		key, _ := cursor.Seek([]byte("d"))
+		assert.Equal(t, "e", string(key), "Seek finds the next key")
+		key, _ = cursor.Last()
+		assert.Equal(t, "e", string(key))
+		key, _ = cursor.Prev()
+		assert.Equal(t, "c", string(key))
+		return nil
+	})
+
+	err := store.View(func(tx KVTx) error {
+		return tx.Bucket([]byte("b")).Put([]byte("x"), []byte("x"))
+	})
+	assert.Equal(t, errStoreReadOnly, err, "Writes are rejected inside View")
+}
+
+func TestMemoryStoreViewIsIsolated(t *testing.T) {
+	store := NewMemoryKV()
+	store.Update(func(tx KVTx) error {
+		bucket, _ := tx.CreateBucket([]byte("b"))
+		return bucket.Put([]byte("k"), []byte("v1"))
+	})
+
+	written, commit, done := make(chan bool), make(chan bool), make(chan error)
+	go func() {
+		done <- store.Update(func(tx KVTx) error {
+			tx.Bucket([]byte("b")).Put([]byte("k"), []byte("v2"))
+			tx.CreateBucket([]byte("c"))
+			written <- true
+			<-commit
+			return nil
+		})
+	}()
+
+	<-written
+	store.View(func(tx KVTx) error {
+		assert.Equal(t, []byte("v1"), tx.Bucket([]byte("b")).Get([]byte("k")), "Writes aren't seen before the Update returns")
+		assert.Nil(t, tx.Bucket([]byte("c")))
+		return nil
+	})
+	commit <- true
+	assert.Nil(t, <-done)
+
+	store.View(func(tx KVTx) error {
+		assert.Equal(t, []byte("v2"), tx.Bucket([]byte("b")).Get([]byte("k")), "Writes are seen once committed")
+		assert.NotNil(t, tx.Bucket([]byte("c")))
+		return nil
+	})
+}
+
+func TestMemoryStoreUpdateRollsBackOnPanic(t *testing.T) {
+	store := NewMemoryKV()
+	store.Update(func(tx KVTx) error {
+		bucket, _ := tx.CreateBucket([]byte("b"))
+		return bucket.Put([]byte("k"), []byte("v1"))
+	})
+
+	assert.Panics(t, func() {
+		store.Update(func(tx KVTx) error {
+			tx.Bucket([]byte("b")).Put([]byte("k"), []byte("v2"))
+			tx.CreateBucket([]byte("c"))
+			panic("failed")
+		})
+	})
+
+	store.View(func(tx KVTx) error {
+		assert.Equal(t, []byte("v1"), tx.Bucket([]byte("b")).Get([]byte("k")), "Nothing written before the panic is kept")
+		assert.Nil(t, tx.Bucket([]byte("c")))
+		return nil
+	})
+	err := store.Update(func(tx KVTx) error {
+		return tx.Bucket([]byte("b")).Put([]byte("k"), []byte("v3"))
+	})
+	assert.Nil(t, err, "The store can still be updated")
+}
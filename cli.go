@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 )
 
 type CLI struct {
@@ -13,11 +15,65 @@ type CLI struct {
 
 func (cli *CLI) PrintUsage() {
 	fmt.Println("Usage:")
-	fmt.Println("  printchain - Print all the blocks of the blockchain")
-	fmt.Println("  createchain -address ADDRESS - Create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("  printchain [-from HEIGHT] [-to HEIGHT] - Print the blocks of the blockchain (all of them by default)")
+	fmt.Println("  createchain -address ADDRESS [-txindex=false] - Create a blockchain and send genesis block reward to ADDRESS")
+	fmt.Println("  reindextx [-disable] - Rebuild (or remove) the transaction index")
+	fmt.Println("  pruneblockchain [-keep N] [-size MB] - Delete old blocks, keeping the latest N blocks and/or MB of blocks")
+	fmt.Println("  dumputxo -out FILE [-height HEIGHT] - Write a snapshot of the UTXO set at HEIGHT (the tip by default)")
+	fmt.Println("  loadutxo -in FILE [-hash HASH] [-txindex=false] - Start a new node from a UTXO snapshot with a trusted HASH")
+	fmt.Println("  exportchain -out FILE - Write the blocks of the chain to a block file")
+	fmt.Println("  importchain -in FILE [-txindex=false] - Validate and add the blocks in a block file (rerun to resume)")
+	fmt.Println("  verifychain [-depth N] [-level LEVEL] - Check the last N blocks (0 for all) and report the first problem found")
+	fmt.Println("       (levels: 0 proof of work, 1 links and indexes, 2 merkle roots, 3 signatures, 4 chainstate)")
+	fmt.Println("  startnode [-miner ADDRESS] [-prune N] [-prunesize MB] - Start a node, optionally mining and/or pruning")
+	fmt.Println("       [-bind HOST:PORT] [-external HOST:PORT] [-seed HOST:PORT]... (listen address, address to advertise, nodes to start from)")
+	fmt.Println("  getpeerinfo [-json] - List the peers of the running node with their latency")
+	fmt.Println("  listbanned - List the hosts the node won't connect to or accept connections from")
+	fmt.Println("  setban -host HOST [-duration DURATION] [-remove] - Ban HOST (for 24h by default), or lift its ban")
+	fmt.Println("  send -from FROM -to TO -amount AMOUNT [-strategy STRATEGY] [-feerate FEE] [-mine] [-node HOST:PORT] - Send AMOUNT of coins from FROM to TO")
+	fmt.Println("       (repeat -to and -amount, or use -payments FILE with CSV or JSON, to pay several recipients in one transaction)")
+	fmt.Println("  createwallet [-label LABEL] - Create a new address in the wallet")
+	fmt.Println("  importaddress -address ADDRESS [-label LABEL] - Watch an address without holding its keys")
+	fmt.Println("  listaddresses [-json] - List every wallet address with its balance, UTXO count, label and kind")
+	fmt.Println("  gethistory [-address ADDRESS] - List the transactions involving ADDRESS (or the whole wallet)")
+	fmt.Println("  signmessage -address ADDRESS -message MESSAGE - Sign MESSAGE with the key of ADDRESS to prove ownership")
+	fmt.Println("  verifymessage -address ADDRESS -signature SIGNATURE -message MESSAGE - Verify a signed message")
 }
 
-func (cli *CLI) Send(from string, to string, amount int, nodeID string, mineNow bool) {
+// stringList is a flag that can be given more than once
+type stringList []string
+
+func (list *stringList) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringList) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+// intList is an integer flag that can be given more than once
+type intList []int
+
+func (list *intList) String() string {
+	return fmt.Sprint(*list)
+}
+
+func (list *intList) Set(value string) error {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*list = append(*list, i)
+	return nil
+}
+
+func (cli *CLI) Send(from string, payments []Payment, nodeID string, mineNow bool, strategy string, feeRate int, node string) {
+	selector, err := GetCoinSelector(strategy)
+	if err != nil {
+		log.Panic(err)
+	}
+
 	blockchain := NewBlockchain(nodeID)
 	utxoSet := UTXOSet{blockchain}
 	defer blockchain.db.Close()
@@ -26,16 +82,20 @@ func (cli *CLI) Send(from string, to string, amount int, nodeID string, mineNow
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet(from)
-	tx := NewUtxoTransaction(&wallet, to, amount, &utxoSet)
+	if _, ok := wallets.WalletDatas[from]; !ok || wallets.Kind(from) == addressKindWatchOnly {
+		fmt.Printf("No keys for '%s' in this wallet\n", from)
+		os.Exit(1)
+	}
+	fees := FeePolicy{PerInput: feeRate, PerOutput: feeRate}
+	tx := NewUtxoTransaction(wallets, from, payments, &utxoSet, selector, fees)
+	wallets.SaveToFile(nodeID) // keep any new change address
 
 	if mineNow {
 		coinbaseTx := NewCoinbaseTx(from, "")
 		txs := []*Transaction{coinbaseTx, tx}
-		newBlock := blockchain.MineBlock(txs)
-		utxoSet.Update(newBlock)
+		blockchain.MineBlock(txs)
 	} else {
-		sendTx(knownNodes[0], tx)
+		sendTxTo(node, tx)
 	}
 
 	fmt.Println("Success")
@@ -60,17 +120,74 @@ func (cli *CLI) Run() {
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	createChainCmd := flag.NewFlagSet("createchain", flag.ExitOnError)
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
+	getHistoryCmd := flag.NewFlagSet("gethistory", flag.ExitOnError)
 	sendCmd := flag.NewFlagSet("send", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	startNodeCmd := flag.NewFlagSet("startnode", flag.ExitOnError)
 	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	reindexTxCmd := flag.NewFlagSet("reindextx", flag.ExitOnError)
+	pruneCmd := flag.NewFlagSet("pruneblockchain", flag.ExitOnError)
+	dumpUTXOCmd := flag.NewFlagSet("dumputxo", flag.ExitOnError)
+	loadUTXOCmd := flag.NewFlagSet("loadutxo", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	importAddressCmd := flag.NewFlagSet("importaddress", flag.ExitOnError)
+	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+	signMessageCmd := flag.NewFlagSet("signmessage", flag.ExitOnError)
+	verifyMessageCmd := flag.NewFlagSet("verifymessage", flag.ExitOnError)
+	getPeerInfoCmd := flag.NewFlagSet("getpeerinfo", flag.ExitOnError)
+	listBannedCmd := flag.NewFlagSet("listbanned", flag.ExitOnError)
+	setBanCmd := flag.NewFlagSet("setban", flag.ExitOnError)
+	printChainFrom := printChainCmd.Int("from", 0, "The lowest block height to print")
+	printChainTo := printChainCmd.Int("to", -1, "The highest block height to print (defaults to the tip)")
 	createChainAddress := createChainCmd.String("address", "", "The address to send genesis block reward to")
-	getBalanceAddress := getBalanceCmd.String("address", "", "The address to check balance for")
+	createChainTxIndex := createChainCmd.Bool("txindex", true, "Maintain an index of transactions by ID")
+	reindexTxDisable := reindexTxCmd.Bool("disable", false, "Remove the transaction index instead of rebuilding it")
+	pruneKeep := pruneCmd.Int("keep", 0, "Keep (at least) this many of the latest blocks")
+	pruneSize := pruneCmd.Int64("size", 0, "Keep at most this many MB of blocks")
+	dumpUTXOOut := dumpUTXOCmd.String("out", "", "The file to write the snapshot to")
+	dumpUTXOHeight := dumpUTXOCmd.Int("height", -1, "The block height to snapshot (defaults to the tip)")
+	loadUTXOIn := loadUTXOCmd.String("in", "", "The snapshot file to load")
+	loadUTXOHash := loadUTXOCmd.String("hash", "", "The trusted snapshot hash, if the chain params don't have one for its height")
+	loadUTXOTxIndex := loadUTXOCmd.Bool("txindex", true, "Maintain an index of transactions by ID")
+	exportChainOut := exportChainCmd.String("out", "", "The block file to write")
+	importChainIn := importChainCmd.String("in", "", "The block file to import")
+	verifyChainDepth := verifyChainCmd.Int("depth", 6, "How many of the latest blocks to check (0 for all)")
+	verifyChainLevel := verifyChainCmd.Int("level", verifyLevelSignatures, "How thorough to be, from 0 to 4")
+	importChainTxIndex := importChainCmd.Bool("txindex", true, "Maintain an index of transactions by ID (when creating the chain)")
+	getBalanceAddress := getBalanceCmd.String("address", "", "The address to check balance for (defaults to the whole wallet)")
+	getHistoryAddress := getHistoryCmd.String("address", "", "The address to list transactions for (defaults to the whole wallet)")
 	sendFromAddress := sendCmd.String("from", "", "The address to send from")
-	sendToAddress := sendCmd.String("to", "", "The address to send to")
-	sendAmount := sendCmd.Int("amount", 0, "The amount to send")
+	var sendToAddresses stringList
+	var sendAmounts intList
+	sendCmd.Var(&sendToAddresses, "to", "The address to send to (repeatable)")
+	sendCmd.Var(&sendAmounts, "amount", "The amount to send (repeatable, paired with -to in order)")
+	sendPaymentsFile := sendCmd.String("payments", "", "CSV (address,amount) or JSON file of payments to make")
 	sendMine := sendCmd.Bool("mine", false, "Mine immediately on the same node.")
+	sendStrategy := sendCmd.String("strategy", defaultCoinSelector, "Coin selection strategy ("+strings.Join(CoinSelectorNames(), ", ")+")")
+	sendFeeRate := sendCmd.Int("feerate", 0, "Fee to pay per transaction input and output")
+	sendNode := sendCmd.String("node", defaultSeedNode, "The node to hand the transaction to")
 	startNodeMiner := startNodeCmd.String("miner", "", "Enable mining mode and send reward to ADDRESS")
+	startNodePrune := startNodeCmd.Int("prune", 0, "Prune old blocks, keeping (at least) this many of the latest")
+	startNodePruneSize := startNodeCmd.Int64("prunesize", 0, "Prune old blocks, keeping at most this many MB of blocks")
+	startNodeBind := startNodeCmd.String("bind", "", "The address to listen on (localhost:NODE_ID by default)")
+	startNodeExternal := startNodeCmd.String("external", "", "The address other nodes can reach this one on (the bind address by default)")
+	var startNodeSeeds stringList
+	startNodeCmd.Var(&startNodeSeeds, "seed", "A node to start from (repeatable, "+defaultSeedNode+" by default)")
+	getPeerInfoJSON := getPeerInfoCmd.Bool("json", false, "Print the peers as JSON")
+	setBanHost := setBanCmd.String("host", "", "The host (or HOST:PORT of a node) to ban")
+	setBanDuration := setBanCmd.Duration("duration", defaultBanDuration, "How long to ban the host for, such as 90m or 48h")
+	setBanRemove := setBanCmd.Bool("remove", false, "Lift the ban on the host instead")
+	createWalletLabel := createWalletCmd.String("label", "", "A label for the new address")
+	importAddressAddress := importAddressCmd.String("address", "", "The address to watch")
+	importAddressLabel := importAddressCmd.String("label", "", "A label for the watched address")
+	listAddressesJSON := listAddressesCmd.Bool("json", false, "Output as JSON")
+	signMessageAddress := signMessageCmd.String("address", "", "The address whose key signs the message")
+	signMessageMessage := signMessageCmd.String("message", "", "The message to sign")
+	verifyMessageAddress := verifyMessageCmd.String("address", "", "The address that signed the message")
+	verifyMessageSignature := verifyMessageCmd.String("signature", "", "The signature produced by signmessage")
+	verifyMessageMessage := verifyMessageCmd.String("message", "", "The message that was signed")
 
 	switch os.Args[1] {
 	case "printchain":
@@ -88,11 +205,51 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "gethistory":
+		err := getHistoryCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "reindexutxo":
 		err := reindexUTXOCmd.Parse(os.Args[2:])
 		if err != nil {
 			log.Panic(err)
 		}
+	case "reindextx":
+		err := reindexTxCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "pruneblockchain":
+		err := pruneCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "dumputxo":
+		err := dumpUTXOCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "loadutxo":
+		err := loadUTXOCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportchain":
+		err := exportChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importchain":
+		err := importChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	case "send":
 		err := sendCmd.Parse(os.Args[2:])
 		if err != nil {
@@ -108,13 +265,48 @@ func (cli *CLI) Run() {
 		if err != nil {
 			log.Panic(err)
 		}
+	case "importaddress":
+		err := importAddressCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listaddresses":
+		err := listAddressesCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "signmessage":
+		err := signMessageCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifymessage":
+		err := verifyMessageCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getpeerinfo":
+		err := getPeerInfoCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "listbanned":
+		err := listBannedCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "setban":
+		err := setBanCmd.Parse(os.Args[2:])
+		if err != nil {
+			log.Panic(err)
+		}
 	default:
 		cli.PrintUsage()
 		os.Exit(1)
 	}
 
 	if printChainCmd.Parsed() {
-		cli.PrintChain(nodeID)
+		cli.PrintChain(nodeID, *printChainFrom, *printChainTo)
 	}
 
 	if createChainCmd.Parsed() {
@@ -122,23 +314,41 @@ func (cli *CLI) Run() {
 			createChainCmd.Usage()
 			os.Exit(1)
 		}
-		cli.CreateBlockchain(*createChainAddress, nodeID)
+		cli.CreateBlockchain(*createChainAddress, nodeID, *createChainTxIndex)
 	}
 
 	if getBalanceCmd.Parsed() {
 		if *getBalanceAddress == "" {
-			getBalanceCmd.Usage()
-			os.Exit(1)
+			cli.GetWalletBalance(nodeID)
+		} else {
+			cli.GetBalance(*getBalanceAddress, nodeID)
 		}
-		cli.GetBalance(*getBalanceAddress, nodeID)
+	}
+
+	if getHistoryCmd.Parsed() {
+		cli.GetHistory(*getHistoryAddress, nodeID)
 	}
 
 	if sendCmd.Parsed() {
-		if *sendFromAddress == "" || *sendToAddress == "" || *sendAmount <= 0 {
+		if *sendFromAddress == "" || *sendFeeRate < 0 {
+			sendCmd.Usage()
+			os.Exit(1)
+		}
+		payments, err := NewPayments(sendToAddresses, sendAmounts)
+		if err == nil && *sendPaymentsFile != "" {
+			var filePayments []Payment
+			filePayments, err = LoadPayments(*sendPaymentsFile)
+			payments = append(payments, filePayments...)
+		}
+		if err == nil {
+			err = ValidatePayments(payments)
+		}
+		if err != nil {
+			fmt.Println(err)
 			sendCmd.Usage()
 			os.Exit(1)
 		}
-		cli.Send(*sendFromAddress, *sendToAddress, *sendAmount, nodeID, *sendMine)
+		cli.Send(*sendFromAddress, payments, nodeID, *sendMine, *sendStrategy, *sendFeeRate, *sendNode)
 	}
 
 	if startNodeCmd.Parsed() {
@@ -147,14 +357,116 @@ func (cli *CLI) Run() {
 			startNodeCmd.Usage()
 			os.Exit(1)
 		}
-		cli.startNode(nodeID, *startNodeMiner)
+		config, err := NewNodeConfig(nodeID, *startNodeBind, *startNodeExternal, startNodeSeeds)
+		if err != nil {
+			fmt.Println(err)
+			startNodeCmd.Usage()
+			os.Exit(1)
+		}
+		cli.startNode(nodeID, *startNodeMiner, *startNodePrune, *startNodePruneSize, config)
 	}
 
 	if createWalletCmd.Parsed() {
-		cli.CreateWallet(nodeID)
+		cli.CreateWallet(*createWalletLabel, nodeID)
+	}
+
+	if importAddressCmd.Parsed() {
+		if *importAddressAddress == "" {
+			importAddressCmd.Usage()
+			os.Exit(1)
+		}
+		cli.ImportAddress(*importAddressAddress, *importAddressLabel, nodeID)
+	}
+
+	if listAddressesCmd.Parsed() {
+		cli.ListAddresses(nodeID, *listAddressesJSON)
 	}
 
 	if reindexUTXOCmd.Parsed() {
 		cli.reindexUTXO(nodeID)
 	}
+
+	if reindexTxCmd.Parsed() {
+		cli.reindexTx(nodeID, *reindexTxDisable)
+	}
+
+	if pruneCmd.Parsed() {
+		if *pruneKeep == 0 && *pruneSize == 0 {
+			pruneCmd.Usage()
+			os.Exit(1)
+		}
+		cli.pruneBlockchain(nodeID, *pruneKeep, *pruneSize)
+	}
+
+	if dumpUTXOCmd.Parsed() {
+		if *dumpUTXOOut == "" {
+			dumpUTXOCmd.Usage()
+			os.Exit(1)
+		}
+		cli.dumpUTXO(nodeID, *dumpUTXOOut, *dumpUTXOHeight)
+	}
+
+	if loadUTXOCmd.Parsed() {
+		if *loadUTXOIn == "" {
+			loadUTXOCmd.Usage()
+			os.Exit(1)
+		}
+		cli.loadUTXO(nodeID, *loadUTXOIn, *loadUTXOHash, *loadUTXOTxIndex)
+	}
+
+	if exportChainCmd.Parsed() {
+		if *exportChainOut == "" {
+			exportChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.exportChain(nodeID, *exportChainOut)
+	}
+
+	if importChainCmd.Parsed() {
+		if *importChainIn == "" {
+			importChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.importChain(nodeID, *importChainIn, *importChainTxIndex)
+	}
+
+	if verifyChainCmd.Parsed() {
+		if *verifyChainDepth < 0 || *verifyChainLevel < 0 || *verifyChainLevel > maxVerifyLevel {
+			verifyChainCmd.Usage()
+			os.Exit(1)
+		}
+		cli.verifyChain(nodeID, *verifyChainDepth, *verifyChainLevel)
+	}
+
+	if signMessageCmd.Parsed() {
+		if *signMessageAddress == "" {
+			signMessageCmd.Usage()
+			os.Exit(1)
+		}
+		cli.SignMessage(*signMessageAddress, *signMessageMessage, nodeID)
+	}
+
+	if verifyMessageCmd.Parsed() {
+		if *verifyMessageAddress == "" || *verifyMessageSignature == "" {
+			verifyMessageCmd.Usage()
+			os.Exit(1)
+		}
+		cli.VerifyMessage(*verifyMessageAddress, *verifyMessageSignature, *verifyMessageMessage)
+	}
+
+	if getPeerInfoCmd.Parsed() {
+		cli.getPeerInfo(nodeID, *getPeerInfoJSON)
+	}
+
+	if listBannedCmd.Parsed() {
+		cli.listBanned(nodeID)
+	}
+
+	if setBanCmd.Parsed() {
+		if *setBanHost == "" || *setBanDuration <= 0 {
+			setBanCmd.Usage()
+			os.Exit(1)
+		}
+		cli.setBan(nodeID, *setBanHost, *setBanDuration, *setBanRemove)
+	}
 }
@@ -2,9 +2,9 @@ package main
 
 import "fmt"
 
-func (cli *CLI) CreateBlockchain(address, nodeID string) {
+func (cli *CLI) CreateBlockchain(address, nodeID string, withTxIndex bool) {
 	println("1. Creating Chain")
-	blockchain := CreateBlockchain(address, nodeID)
+	blockchain := CreateBlockchain(address, nodeID, withTxIndex)
 	defer blockchain.db.Close()
 
 	utxoSet := UTXOSet{blockchain}
@@ -1,10 +1,17 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+)
 
-func (cli *CLI) CreateWallet(nodeID string) {
-	wallets, _ := NewWallets(nodeID)
-	address := wallets.CreateWallet()
+func (cli *CLI) CreateWallet(label, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	address := wallets.CreateWallet(label)
 	wallets.SaveToFile(nodeID)
 
 	fmt.Printf("Your new address: %s\n", address)
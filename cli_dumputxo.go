@@ -0,0 +1,33 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// dumpUTXO writes a snapshot of the chainstate at height (the tip if negative) to path
+func (cli *CLI) dumpUTXO(nodeID, path string, height int) {
+	bc := NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	if height < 0 {
+		height = bc.GetBestHeight()
+	}
+	snapshot, err := bc.CreateUtxoSnapshot(height)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+	if err := WriteUtxoSnapshot(file, snapshot); err != nil {
+		log.Panic(err)
+	}
+
+	fmt.Printf("Wrote %d unspent outputs at height %d (block %x) to %s\n", len(snapshot.Utxos), snapshot.Height, snapshot.BlockHash, path)
+	fmt.Printf("Snapshot hash: %x\n", snapshot.Hash)
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// exportChain writes the main chain to a block file at path
+func (cli *CLI) exportChain(nodeID, path string) {
+	bc := NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	count, err := bc.ExportChain(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Exported %d blocks to %s\n", count, path)
+}
@@ -1,18 +1,53 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"log"
+)
 
+// GetBalance prints the balance of address together with the change addresses holding its change
 func (cli *CLI) GetBalance(address, nodeID string) {
 	bc := NewBlockchain(nodeID)
 	utxo := UTXOSet{bc}
 	defer bc.db.Close()
 
-	balance := 0
-	utxos := utxo.FindUtxos(ConvertBase58AddressToPubKeyHash(address))
+	// An address outside this node's wallet has no change addresses, so a missing wallet is fine
+	wallets, _ := NewWallets(nodeID)
 
-	for _, utxo := range utxos {
-		balance = balance + utxo.Value
+	balance := 0
+	for _, spendingAddress := range wallets.GetSpendingAddresses(address) {
+		for _, utxo := range utxo.FindUtxos(ConvertBase58AddressToPubKeyHash(spendingAddress)) {
+			balance = balance + utxo.Value
+		}
 	}
 
 	fmt.Printf("Balance of '%s': %d\n", address, balance)
 }
+
+// GetWalletBalance prints the balance of every address in the wallet, including change addresses.
+// Watch-only addresses are listed but not counted towards the total.
+func (cli *CLI) GetWalletBalance(nodeID string) {
+	bc := NewBlockchain(nodeID)
+	utxo := UTXOSet{bc}
+	defer bc.db.Close()
+
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	total := 0
+	for _, address := range wallets.GetAddresses() {
+		balance := 0
+		for _, output := range utxo.FindUtxos(ConvertBase58AddressToPubKeyHash(address)) {
+			balance = balance + output.Value
+		}
+		if wallets.Kind(address) != addressKindWatchOnly {
+			total = total + balance
+		}
+
+		fmt.Printf("Balance of '%s' (%s): %d\n", address, wallets.Kind(address), balance)
+	}
+
+	fmt.Printf("Total wallet balance: %d\n", total)
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"testing"
+)
+
+// captureStdout returns what fn prints, for testing CLI commands
+func captureStdout(t *testing.T, fn func()) string {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+
+	fn()
+	w.Close()
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+// newTestWalletChain mines a payment of 3 from Alice to Bob and saves the wallet, leaving the chain
+// closed so CLI commands can open it
+func newTestWalletChain(t *testing.T) (wallets *Wallets, alice, bob, change string) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob = addresses[0], addresses[1]
+	bc := newTestBlockchain(t, alice, false)
+	utxoSet := UTXOSet{bc}
+
+	mineTx(bc, alice, NewUtxoTransaction(wallets, alice, []Payment{{bob, 3}}, &utxoSet, LargestFirstSelector{}, FeePolicy{}))
+	change = wallets.GetSpendingAddresses(alice)[1]
+	wallets.SaveToFile(testNodeID)
+	bc.db.Close()
+	return wallets, alice, bob, change
+}
+
+func TestGetBalanceIncludesChange(t *testing.T) {
+	_, alice, bob, _ := newTestWalletChain(t)
+	cli := CLI{}
+
+	out := captureStdout(t, func() { cli.GetBalance(alice, testNodeID) })
+	assert.Equal(t, fmt.Sprintf("Balance of '%s': %d\n", alice, 17), out, "The block reward plus the change from paying Bob")
+
+	out = captureStdout(t, func() { cli.GetBalance(bob, testNodeID) })
+	assert.Equal(t, fmt.Sprintf("Balance of '%s': %d\n", bob, 3), out)
+}
+
+func TestGetWalletBalance(t *testing.T) {
+	_, alice, bob, change := newTestWalletChain(t)
+
+	out := captureStdout(t, func() { (&CLI{}).GetWalletBalance(testNodeID) })
+	assert.Contains(t, out, fmt.Sprintf("Balance of '%s' (external): 10\n", alice))
+	assert.Contains(t, out, fmt.Sprintf("Balance of '%s' (external): 3\n", bob))
+	assert.Contains(t, out, fmt.Sprintf("Balance of '%s' (change): 7\n", change))
+	assert.Contains(t, out, "Total wallet balance: 20\n")
+}
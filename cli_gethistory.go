@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+)
+
+// GetHistory prints the transactions involving address, or every address in the wallet
+// (including change addresses) if none is given
+func (cli *CLI) GetHistory(address, nodeID string) {
+	bc := NewBlockchain(nodeID)
+	utxoSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	addresses := []string{address}
+	if address == "" {
+		wallets, err := NewWallets(nodeID)
+		if err != nil {
+			log.Panic(err)
+		}
+		addresses = wallets.GetAddresses()
+	}
+
+	pruned := bc.IsPruned()
+	for _, address := range addresses {
+		pubKeyHash := ConvertBase58AddressToPubKeyHash(address)
+		fmt.Printf("History of '%s':\n", address)
+
+		for _, entry := range utxoSet.FindHistory(pubKeyHash) {
+			tx, err := bc.FindTx(entry.TxID)
+			if err != nil && pruned {
+				fmt.Printf("  height %-6d %x  (block pruned)\n", entry.Height, entry.TxID)
+				continue
+			} else if err != nil {
+				log.Panic(err)
+			}
+
+			received := 0
+			for _, output := range tx.Outputs {
+				if output.IsLockedWithKey(pubKeyHash) {
+					received = received + output.Value
+				}
+			}
+
+			spent := "0"
+			if !tx.IsCoinbase() {
+				total := 0
+				for _, input := range tx.Inputs {
+					if !input.UsesKey(pubKeyHash) {
+						continue
+					}
+					prevTx, err := bc.FindTx(input.TxOutputID)
+					if err != nil && pruned {
+						total = -1 // the spent output was in a pruned block
+						break
+					} else if err != nil {
+						log.Panic(err)
+					}
+					total = total + prevTx.Outputs[input.TxOutputIndex].Value
+				}
+				spent = strconv.Itoa(total)
+				if total < 0 {
+					spent = "unknown (block pruned)"
+				}
+			}
+
+			fmt.Printf("  height %-6d %x  received: %-6d spent: %s\n", entry.Height, entry.TxID, received, spent)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// getPeerInfo prints the peers of the running node, with their latency
+func (cli *CLI) getPeerInfo(nodeID string, asJSON bool) {
+	info, err := ReadPeerInfo(fmt.Sprintf(peerInfoFile, nodeID))
+	if os.IsNotExist(err) {
+		fmt.Printf("Node %s isn't running\n", nodeID)
+		os.Exit(1)
+	} else if err != nil {
+		log.Panic(err)
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(info.Peers); err != nil {
+			log.Panic(err)
+		}
+		return
+	}
+
+	fmt.Printf("%d peers as of %s\n", len(info.Peers), info.Updated.Format(time.RFC3339))
+	for _, peer := range info.Peers {
+		direction := "outbound"
+		if peer.Inbound {
+			direction = "inbound"
+		}
+		ping := "-"
+		if peer.PingTime > 0 {
+			ping = fmt.Sprintf("%s (min %s)", peer.PingTime.Round(time.Microsecond), peer.MinPing.Round(time.Microsecond))
+		}
+		if peer.PingWait > 0 {
+			ping = ping + fmt.Sprintf(", waiting %s", peer.PingWait.Round(time.Second))
+		}
+		fmt.Printf("%-22s %-8s %s protocol %d  height %-6d connected %-8s ping %s  banscore %d\n",
+			peer.Addr, direction, peer.UserAgent, peer.Version, peer.BestHeight,
+			info.Updated.Sub(peer.ConnectedAt).Round(time.Second), ping, peer.BanScore)
+	}
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func (cli *CLI) ImportAddress(address, label, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := wallets.ImportWatchOnly(address, label); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	wallets.SaveToFile(nodeID)
+
+	fmt.Printf("Watching address: %s\n", address)
+}
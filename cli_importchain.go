@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// importProgressInterval is how many blocks are read between progress reports
+const importProgressInterval = 100
+
+// importChain validates and connects the blocks in the block file at path, creating the chain
+// from the file's genesis block if this node doesn't have one yet. Blocks already in the chain
+// are skipped, so an interrupted import can be resumed by running it again.
+func (cli *CLI) importChain(nodeID, path string, withTxIndex bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	reader, err := NewBlockFileReader(file)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	genesis, err := reader.ReadBlock()
+	if err == nil && len(genesis.PrevBlockHash) != 0 {
+		err = fmt.Errorf("block file doesn't start with a genesis block")
+	}
+	if err == nil {
+		err = CheckProofOfWork(genesis)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	var bc *Blockchain
+	if dbFile := fmt.Sprintf(dbFile, nodeID); dbExists(dbFile) {
+		bc = NewBlockchain(nodeID)
+		if hash, _ := bc.GetBlockHashByHeight(0); !bytes.Equal(hash, genesis.Hash) {
+			bc.db.Close()
+			fmt.Println("Block file is for a different chain")
+			os.Exit(1)
+		}
+	} else {
+		db, err := OpenBoltStore(dbFile)
+		if err != nil {
+			log.Panic(err)
+		}
+		bc = CreateBlockchainFromGenesis(genesis, db, withTxIndex)
+		UTXOSet{bc}.Reindex()
+	}
+	defer bc.db.Close()
+
+	read, imported := 1, 0
+	for {
+		block, err := reader.ReadBlock()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			var added bool
+			added, err = bc.ImportBlock(block)
+			if added {
+				imported++
+			}
+		}
+		if err != nil {
+			fmt.Printf("Import stopped after %d blocks (%d new): %s\n", read, imported, err)
+			os.Exit(1)
+		}
+
+		read++
+		if read%importProgressInterval == 0 {
+			fmt.Printf("Read %d blocks, imported %d, height %d\n", read, imported, block.Height)
+		}
+	}
+
+	pruneBlocks(bc)
+	fmt.Printf("Imported %d new blocks of %d, chain height is %d\n", imported, read, bc.GetBestHeight())
+}
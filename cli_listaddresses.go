@@ -1,19 +1,54 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"os"
 )
 
-func (cli *CLI) listAddresses(nodeID string) {
+type AddressInfo struct {
+	Address string `json:"address"`
+	Kind    string `json:"kind"`
+	Label   string `json:"label,omitempty"`
+	Balance int    `json:"balance"`
+	Utxos   int    `json:"utxos"`
+}
+
+func (cli *CLI) ListAddresses(nodeID string, asJSON bool) {
 	wallets, err := NewWallets(nodeID)
 	if err != nil {
 		log.Panic(err)
 	}
-	wallet := wallets.GetWallet(nodeID)
-	addresses := wallet.GetAddress()
 
-	for _, address := range addresses {
-		fmt.Println(address)
+	bc := NewBlockchain(nodeID)
+	utxoSet := UTXOSet{bc}
+	defer bc.db.Close()
+
+	var infos []AddressInfo
+	for _, address := range wallets.GetAddresses() {
+		info := AddressInfo{
+			Address: address,
+			Kind:    wallets.Kind(address),
+			Label:   wallets.WalletDatas[address].Label,
+		}
+		for _, output := range utxoSet.FindUtxos(ConvertBase58AddressToPubKeyHash(address)) {
+			info.Balance = info.Balance + output.Value
+			info.Utxos++
+		}
+		infos = append(infos, info)
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(infos); err != nil {
+			log.Panic(err)
+		}
+		return
+	}
+
+	for _, info := range infos {
+		fmt.Printf("%s  %-10s  balance: %-6d utxos: %-4d %s\n", info.Address, info.Kind, info.Balance, info.Utxos, info.Label)
 	}
 }
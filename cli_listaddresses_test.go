@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestListAddresses(t *testing.T) {
+	_, alice, bob, change := newTestWalletChain(t)
+	watched := string(NewWalletData().GetWallet().GetAddress())
+
+	wallets, err := NewWallets(testNodeID)
+	assert.Nil(t, err)
+	wallets.WalletDatas[alice].Label = "savings"
+	assert.Nil(t, wallets.ImportWatchOnly(watched, "cold storage"))
+	wallets.SaveToFile(testNodeID)
+
+	out := captureStdout(t, func() { (&CLI{}).ListAddresses(testNodeID, false) })
+	assert.Contains(t, out, fmt.Sprintf("%s  external    balance: 10     utxos: 1    savings\n", alice))
+	assert.Contains(t, out, fmt.Sprintf("%s  external    balance: 3      utxos: 1    \n", bob))
+	assert.Contains(t, out, fmt.Sprintf("%s  change      balance: 7      utxos: 1    \n", change))
+	assert.Contains(t, out, fmt.Sprintf("%s  watch-only  balance: 0      utxos: 0    cold storage\n", watched))
+
+	var infos []AddressInfo
+	out = captureStdout(t, func() { (&CLI{}).ListAddresses(testNodeID, true) })
+	assert.Nil(t, json.Unmarshal([]byte(out), &infos))
+	assert.ElementsMatch(t, []AddressInfo{
+		{Address: alice, Kind: addressKindExternal, Label: "savings", Balance: 10, Utxos: 1},
+		{Address: bob, Kind: addressKindExternal, Balance: 3, Utxos: 1},
+		{Address: change, Kind: addressKindChange, Balance: 7, Utxos: 1},
+		{Address: watched, Kind: addressKindWatchOnly, Label: "cold storage"},
+	}, infos)
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// listBanned prints the hosts the node is banning and until when
+func (cli *CLI) listBanned(nodeID string) {
+	bans, err := LoadBanList(fmt.Sprintf(banListFile, nodeID))
+	if err != nil {
+		log.Panic(err)
+	}
+
+	current := bans.Bans()
+	if len(current) == 0 {
+		fmt.Println("No hosts are banned")
+		return
+	}
+	for _, ban := range current {
+		fmt.Printf("%-40s until %s\n", ban.Host, ban.Until.Format(time.RFC3339))
+	}
+}
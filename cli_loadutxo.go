@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+// loadUTXO bootstraps a new node from the snapshot at path. The snapshot must match the hash
+// in the chain params for its height or, failing that, expectedHash.
+func (cli *CLI) loadUTXO(nodeID, path, expectedHash string, withTxIndex bool) {
+	dbFile := fmt.Sprintf(dbFile, nodeID)
+	if dbExists(dbFile) {
+		fmt.Println("Blockchain already exists.")
+		os.Exit(1)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	snapshot, err := ReadUtxoSnapshot(file)
+	file.Close()
+	if err == nil {
+		err = snapshot.CheckTrusted(expectedHash)
+	}
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	db, err := OpenBoltStore(dbFile)
+	if err != nil {
+		log.Panic(err)
+	}
+	bc := LoadBlockchainFromSnapshot(db, snapshot, withTxIndex)
+	defer bc.db.Close()
+
+	fmt.Printf("Loaded %d unspent outputs at height %d, start the node to sync the rest of the chain\n", len(snapshot.Utxos), snapshot.Height)
+}
@@ -5,13 +5,23 @@ import (
 	"strconv"
 )
 
-func (cli *CLI) PrintChain(nodeID string) {
+// PrintChain prints the main chain blocks between heights from and to (inclusive), newest first.
+// A negative to means up to the tip.
+func (cli *CLI) PrintChain(nodeID string, from, to int) {
 	// Just exec NewBlockChain here (which actually loads the thing)
 	bc := NewBlockchain(nodeID)
 	defer bc.db.Close()
-	it := bc.Iterator()
-	for {
-		block := it.Next()
+
+	if to < 0 {
+		to = bc.GetBestHeight()
+	}
+	if prunedHeight := bc.GetPruneState().PrunedHeight; from < prunedHeight {
+		fmt.Printf("Blocks below height %d have been pruned\n\n", prunedHeight)
+		from = prunedHeight
+	}
+	blocks := bc.GetBlocksInRange(from, to)
+	for i := len(blocks) - 1; i >= 0; i-- {
+		block := blocks[i]
 
 		fmt.Printf("============ Block %x ============\n", block.Hash)
 		fmt.Printf("Height: %d\n", block.Height)
@@ -22,9 +32,5 @@ func (cli *CLI) PrintChain(nodeID string) {
 			fmt.Println(tx)
 		}
 		fmt.Printf("\n\n")
-
-		if len(block.PrevBlockHash) == 0 {
-			break
-		}
 	}
 }
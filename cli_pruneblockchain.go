@@ -0,0 +1,22 @@
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// pruneBlockchain turns on pruning with the given targets (keep blocks and/or size in MB) and
+// deletes the old blocks straight away
+func (cli *CLI) pruneBlockchain(nodeID string, keepBlocks int, targetSizeMB int64) {
+	bc := NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	if err := bc.SetPruneTarget(keepBlocks, targetSizeMB*1024*1024); err != nil {
+		log.Panic(err)
+	}
+	pruned, err := bc.Prune()
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Pruned %d blocks, blocks below height %d are no longer stored\n", pruned, bc.GetPruneState().PrunedHeight)
+}
@@ -0,0 +1,16 @@
+package main
+
+import "fmt"
+
+func (cli *CLI) reindexTx(nodeID string, disable bool) {
+	bc := NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	if disable {
+		bc.DropTxIndex()
+		fmt.Println("Transaction index removed")
+		return
+	}
+	bc.ReindexTxs()
+	fmt.Println("Transaction index rebuilt")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+)
+
+// setBan bans host for duration, or lifts its ban. A running node picks the change up within
+// connectInterval and disconnects peers from a newly banned host.
+func (cli *CLI) setBan(nodeID, host string, duration time.Duration, remove bool) {
+	// A peer address names the host it is on
+	if addrHost, _, err := net.SplitHostPort(host); err == nil {
+		host = addrHost
+	}
+
+	bans, err := LoadBanList(fmt.Sprintf(banListFile, nodeID))
+	if err != nil {
+		log.Panic(err)
+	}
+	if remove {
+		if !bans.Unban(host) {
+			fmt.Printf("%s isn't banned\n", host)
+			return
+		}
+		fmt.Printf("Unbanned %s\n", host)
+	} else {
+		until := time.Now().Add(duration)
+		bans.Ban(host, until)
+		fmt.Printf("Banned %s until %s\n", host, until.Format(time.RFC3339))
+	}
+	if err := bans.Save(); err != nil {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func (cli *CLI) SignMessage(address, message, nodeID string) {
+	wallets, err := NewWallets(nodeID)
+	if err != nil {
+		log.Panic(err)
+	}
+	if _, ok := wallets.WalletDatas[address]; !ok || wallets.Kind(address) == addressKindWatchOnly {
+		fmt.Printf("No keys for '%s' in this wallet\n", address)
+		os.Exit(1)
+	}
+
+	wallet := wallets.GetWallet(address)
+	signature, err := wallet.SignMessage(message)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println(signature)
+}
@@ -1,11 +1,25 @@
 package main
 
-import "fmt"
+import (
+	"fmt"
+	"log"
+)
 
-func (cli *CLI) startNode(nodeID, minerAddress string) {
+// startNode runs the node. Non zero pruneBlocks or pruneSizeMB switch the chain into pruning mode first.
+func (cli *CLI) startNode(nodeID, minerAddress string, pruneBlocks int, pruneSizeMB int64, config NodeConfig) {
 	fmt.Printf("Starting node %s\n", nodeID)
+	if pruneBlocks > 0 || pruneSizeMB > 0 {
+		bc := NewBlockchain(nodeID)
+		err := bc.SetPruneTarget(pruneBlocks, pruneSizeMB*1024*1024)
+		bc.db.Close()
+		if err != nil {
+			log.Panic(err)
+		}
+		fmt.Println("Pruning is on. Old blocks will be deleted as new ones arrive")
+	}
 	if len(minerAddress) > 0 {
 		fmt.Printf("Mining is on. Address to receive rewards: %s\n", minerAddress)
 	}
-	StartServer(nodeID, minerAddress)
+	fmt.Printf("Listening on %s, advertising %s\n", config.BindAddress, config.ExternalAddress)
+	StartServer(nodeID, minerAddress, config)
 }
@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// verifyChain checks the latest depth blocks (all of them if depth is 0) at the given level
+func (cli *CLI) verifyChain(nodeID string, depth, level int) {
+	bc := NewBlockchain(nodeID)
+	defer bc.db.Close()
+
+	if depth == 0 {
+		fmt.Printf("Verifying every block at level %d\n", level)
+	} else {
+		fmt.Printf("Verifying the last %d blocks at level %d\n", depth, level)
+	}
+	checked, err := bc.VerifyChain(depth, level)
+	if err != nil {
+		fmt.Printf("Chain is inconsistent after checking %d blocks: %s\n", checked, err)
+		os.Exit(1)
+	}
+	if level >= verifyLevelChainstate && bc.IsPruned() {
+		fmt.Println("Skipped comparing the chainstate, the chain has been pruned")
+	}
+	fmt.Printf("No problems found in %d blocks\n", checked)
+}
@@ -0,0 +1,19 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func (cli *CLI) VerifyMessage(address, signature, message string) {
+	valid, err := VerifyMessage(address, signature, message)
+	if err != nil {
+		fmt.Printf("Unable to verify: %s\n", err)
+		os.Exit(1)
+	}
+	if !valid {
+		fmt.Println("Signature is NOT valid")
+		os.Exit(1)
+	}
+	fmt.Println("Signature is valid")
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+const defaultCoinSelector = "bnb"
+const bnbMaxTries = 100000
+const defaultDustThreshold = 3
+const defaultMaxInputs = 50
+
+var errInsufficientFunds = errors.New("not enough funds")
+var errNoExactMatch = errors.New("no exact match found")
+
+// SpendableOutput is an unspent output together with the reference an input needs to spend it
+type SpendableOutput struct {
+	TxID   []byte
+	Index  int
+	Output TxOutput
+}
+
+// FeePolicy prices a transaction by the number of inputs and outputs it has.
+// A zero FeePolicy means transactions are free (the historical behaviour).
+type FeePolicy struct {
+	PerInput  int
+	PerOutput int
+}
+
+// Fee is the cost of a transaction with the given number of inputs and outputs
+func (fees FeePolicy) Fee(inputs, outputs int) int {
+	return inputs*fees.PerInput + outputs*fees.PerOutput
+}
+
+// EffectiveValue is what an output contributes to a payment once the cost of spending it is paid
+func (fees FeePolicy) EffectiveValue(utxo SpendableOutput) int {
+	return utxo.Output.Value - fees.PerInput
+}
+
+// CoinSelection is the result of choosing which outputs fund a payment
+type CoinSelection struct {
+	Outputs []SpendableOutput
+	Total   int
+	Fee     int
+	Change  int
+}
+
+// CoinSelector chooses outputs from utxos worth at least amount plus the fee for
+// spending them into payees outputs (and a change output if one is needed)
+type CoinSelector interface {
+	Select(utxos []SpendableOutput, amount, payees int, fees FeePolicy) (CoinSelection, error)
+}
+
+var coinSelectors = map[string]CoinSelector{
+	"keyorder":    KeyOrderSelector{},
+	"largest":     LargestFirstSelector{},
+	"bnb":         BranchAndBoundSelector{Fallback: LargestFirstSelector{}},
+	"random":      RandomImproveSelector{MaxInputs: defaultMaxInputs},
+	"consolidate": ConsolidateSelector{DustThreshold: defaultDustThreshold, MaxInputs: defaultMaxInputs},
+}
+
+// CoinSelectorNames lists the registered strategies in a stable order (for usage output)
+func CoinSelectorNames() []string {
+	var names []string
+	for name := range coinSelectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func GetCoinSelector(name string) (CoinSelector, error) {
+	selector, ok := coinSelectors[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown coin selection strategy %q (choose from %s)", name, strings.Join(CoinSelectorNames(), ", "))
+	}
+	return selector, nil
+}
+
+// newCoinSelection evaluates a set of chosen outputs against the payment, working out the fee
+// and whether a change output is worth creating. Change that would cost more to spend than it
+// is worth is left to the fee instead.
+func newCoinSelection(chosen []SpendableOutput, amount, payees int, fees FeePolicy) (CoinSelection, error) {
+	total := 0
+	for _, utxo := range chosen {
+		total = total + utxo.Output.Value
+	}
+
+	if total < amount+fees.Fee(len(chosen), payees) {
+		return CoinSelection{}, errInsufficientFunds
+	}
+
+	changeFee := fees.Fee(len(chosen), payees+1)
+	change := total - amount - changeFee
+	if change > fees.PerInput {
+		return CoinSelection{Outputs: chosen, Total: total, Fee: changeFee, Change: change}, nil
+	}
+	return CoinSelection{Outputs: chosen, Total: total, Fee: total - amount}, nil
+}
+
+// economicOutputs drops outputs that cost more to spend than they are worth
+func economicOutputs(utxos []SpendableOutput, fees FeePolicy) []SpendableOutput {
+	var economic []SpendableOutput
+	for _, utxo := range utxos {
+		if fees.EffectiveValue(utxo) > 0 {
+			economic = append(economic, utxo)
+		}
+	}
+	return economic
+}
+
+// accumulate takes outputs in the given order until the payment (and its fee) is covered
+func accumulate(utxos []SpendableOutput, amount, payees int, fees FeePolicy) (CoinSelection, error) {
+	var chosen []SpendableOutput
+	total := 0
+	for _, utxo := range utxos {
+		if total >= amount+fees.Fee(len(chosen), payees) {
+			break
+		}
+		chosen = append(chosen, utxo)
+		total = total + utxo.Output.Value
+	}
+	return newCoinSelection(chosen, amount, payees, fees)
+}
+
+// KeyOrderSelector takes outputs in the order they are stored in the chainstate
+type KeyOrderSelector struct{}
+
+func (s KeyOrderSelector) Select(utxos []SpendableOutput, amount, payees int, fees FeePolicy) (CoinSelection, error) {
+	return accumulate(economicOutputs(utxos, fees), amount, payees, fees)
+}
+
+// LargestFirstSelector spends the biggest outputs first, keeping the number of inputs small
+type LargestFirstSelector struct{}
+
+func (s LargestFirstSelector) Select(utxos []SpendableOutput, amount, payees int, fees FeePolicy) (CoinSelection, error) {
+	candidates := economicOutputs(utxos, fees)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Output.Value > candidates[j].Output.Value
+	})
+	return accumulate(candidates, amount, payees, fees)
+}
+
+// BranchAndBoundSelector searches for a set of outputs that pays the amount exactly (give or
+// take the cost of a change output), so no change output needs to be created at all.
+// If there is no such set the Fallback strategy is used instead.
+type BranchAndBoundSelector struct {
+	Fallback CoinSelector
+}
+
+func (s BranchAndBoundSelector) Select(utxos []SpendableOutput, amount, payees int, fees FeePolicy) (CoinSelection, error) {
+	chosen, err := s.search(utxos, amount, payees, fees)
+	if err != nil {
+		if s.Fallback == nil {
+			return CoinSelection{}, err
+		}
+		return s.Fallback.Select(utxos, amount, payees, fees)
+	}
+	return newCoinSelection(chosen, amount, payees, fees)
+}
+
+func (s BranchAndBoundSelector) search(utxos []SpendableOutput, amount, payees int, fees FeePolicy) ([]SpendableOutput, error) {
+	candidates := economicOutputs(utxos, fees)
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Output.Value > candidates[j].Output.Value
+	})
+
+	// Input fees are accounted for by using effective values, so the target only needs the outputs
+	target := amount + fees.Fee(0, payees)
+	costOfChange := fees.Fee(1, 1)
+
+	// remaining[i] is the most the candidates from i onwards could still add
+	remaining := make([]int, len(candidates)+1)
+	for i := len(candidates) - 1; i >= 0; i-- {
+		remaining[i] = remaining[i+1] + fees.EffectiveValue(candidates[i])
+	}
+
+	var best []int
+	bestExcess := -1
+	var current []int
+	tries := 0
+
+	var walk func(depth, value int)
+	walk = func(depth, value int) {
+		tries++
+		if tries > bnbMaxTries || value > target+costOfChange {
+			return
+		}
+		if value >= target {
+			excess := value - target
+			if bestExcess < 0 || excess < bestExcess {
+				best = append([]int{}, current...)
+				bestExcess = excess
+			}
+			return
+		}
+		if depth == len(candidates) || value+remaining[depth] < target {
+			return
+		}
+
+		// Try with this output first, then without it
+		current = append(current, depth)
+		walk(depth+1, value+fees.EffectiveValue(candidates[depth]))
+		current = current[:len(current)-1]
+		walk(depth+1, value)
+	}
+	walk(0, 0)
+
+	if best == nil {
+		return nil, errNoExactMatch
+	}
+
+	var chosen []SpendableOutput
+	for _, i := range best {
+		chosen = append(chosen, candidates[i])
+	}
+	return chosen, nil
+}
+
+// RandomImproveSelector picks outputs at random until the payment is covered and then keeps
+// adding random outputs while doing so moves the change closer to the payment amount.
+// Change of a similar size to the payment makes it harder to tell which output is which, and
+// over time keeps the UTXO set from fragmenting into lots of tiny outputs.
+type RandomImproveSelector struct {
+	MaxInputs int
+	Rand      *rand.Rand
+}
+
+func (s RandomImproveSelector) Select(utxos []SpendableOutput, amount, payees int, fees FeePolicy) (CoinSelection, error) {
+	candidates := economicOutputs(utxos, fees)
+	shuffle := rand.Shuffle
+	if s.Rand != nil {
+		shuffle = s.Rand.Shuffle
+	}
+	shuffle(len(candidates), func(i, j int) {
+		candidates[i], candidates[j] = candidates[j], candidates[i]
+	})
+
+	// Random select phase
+	var chosen []SpendableOutput
+	total := 0
+	next := 0
+	for ; next < len(candidates) && total < amount+fees.Fee(len(chosen), payees); next++ {
+		chosen = append(chosen, candidates[next])
+		total = total + candidates[next].Output.Value
+	}
+	if total < amount+fees.Fee(len(chosen), payees) {
+		return CoinSelection{}, errInsufficientFunds
+	}
+
+	// Improve phase, aiming for change equal to the amount and never more than double it
+	distance := func(change int) int {
+		if change > amount {
+			return change - amount
+		}
+		return amount - change
+	}
+	for ; next < len(candidates); next++ {
+		if s.MaxInputs > 0 && len(chosen) >= s.MaxInputs {
+			break
+		}
+		change := total - amount - fees.Fee(len(chosen), payees+1)
+		improved := total + candidates[next].Output.Value - amount - fees.Fee(len(chosen)+1, payees+1)
+		if improved > 2*amount || distance(improved) >= distance(change) {
+			continue
+		}
+		chosen = append(chosen, candidates[next])
+		total = total + candidates[next].Output.Value
+	}
+
+	return newCoinSelection(chosen, amount, payees, fees)
+}
+
+// ConsolidateSelector sweeps up small outputs (below DustThreshold) along with the payment,
+// smallest first, and tops up with the largest outputs if the dust is not enough.
+// This is most useful when fees are low and the wallet has accumulated lots of small outputs.
+type ConsolidateSelector struct {
+	DustThreshold int
+	MaxInputs     int
+}
+
+func (s ConsolidateSelector) Select(utxos []SpendableOutput, amount, payees int, fees FeePolicy) (CoinSelection, error) {
+	var dust, rest []SpendableOutput
+	for _, utxo := range economicOutputs(utxos, fees) {
+		if utxo.Output.Value < s.DustThreshold {
+			dust = append(dust, utxo)
+		} else {
+			rest = append(rest, utxo)
+		}
+	}
+	sort.SliceStable(dust, func(i, j int) bool {
+		return dust[i].Output.Value < dust[j].Output.Value
+	})
+	sort.SliceStable(rest, func(i, j int) bool {
+		return rest[i].Output.Value > rest[j].Output.Value
+	})
+
+	var chosen []SpendableOutput
+	total := 0
+	for _, utxo := range dust {
+		if s.MaxInputs > 0 && len(chosen) >= s.MaxInputs {
+			break
+		}
+		chosen = append(chosen, utxo)
+		total = total + utxo.Output.Value
+	}
+	for _, utxo := range rest {
+		if total >= amount+fees.Fee(len(chosen), payees) {
+			break
+		}
+		chosen = append(chosen, utxo)
+		total = total + utxo.Output.Value
+	}
+
+	return newCoinSelection(chosen, amount, payees, fees)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"math/rand"
+	"testing"
+)
+
+func testUtxos(values ...int) []SpendableOutput {
+	var utxos []SpendableOutput
+	for i, value := range values {
+		utxos = append(utxos, SpendableOutput{[]byte{byte(i)}, 0, TxOutput{value, []byte("owner")}})
+	}
+	return utxos
+}
+
+func selectedValues(selection CoinSelection) []int {
+	var values []int
+	for _, utxo := range selection.Outputs {
+		values = append(values, utxo.Output.Value)
+	}
+	return values
+}
+
+func TestKeyOrderSelector(t *testing.T) {
+	selection, err := KeyOrderSelector{}.Select(testUtxos(3, 10, 5), 8, 1, FeePolicy{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{3, 10}, selectedValues(selection), "Outputs are taken in stored order")
+	assert.Equal(t, 5, selection.Change, "Change is the excess")
+}
+
+func TestLargestFirstSelector(t *testing.T) {
+	selection, err := LargestFirstSelector{}.Select(testUtxos(3, 10, 5), 8, 1, FeePolicy{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{10}, selectedValues(selection), "Largest output covers the amount alone")
+	assert.Equal(t, 2, selection.Change)
+}
+
+func TestBranchAndBoundSelectorExactMatch(t *testing.T) {
+	selection, err := BranchAndBoundSelector{}.Select(testUtxos(3, 10, 5, 7), 8, 1, FeePolicy{})
+
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []int{3, 5}, selectedValues(selection), "Exact match is found")
+	assert.Equal(t, 0, selection.Change, "No change is needed")
+}
+
+func TestBranchAndBoundSelectorFallback(t *testing.T) {
+	_, err := BranchAndBoundSelector{}.Select(testUtxos(10, 10), 8, 1, FeePolicy{})
+	assert.Equal(t, errNoExactMatch, err)
+
+	selection, err := BranchAndBoundSelector{Fallback: LargestFirstSelector{}}.Select(testUtxos(10, 10), 8, 1, FeePolicy{})
+	assert.Nil(t, err)
+	assert.Equal(t, 2, selection.Change, "Fallback strategy funds the payment")
+}
+
+func TestSelectorsAreFeeAware(t *testing.T) {
+	fees := FeePolicy{PerInput: 1, PerOutput: 1}
+	selection, err := LargestFirstSelector{}.Select(testUtxos(1, 6, 5), 8, 1, fees)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{6, 5}, selectedValues(selection), "Uneconomic outputs are never spent")
+	assert.Equal(t, 0, selection.Change, "Change too small to spend goes to the fee")
+	assert.Equal(t, 3, selection.Fee, "Fee covers two inputs and the payee")
+}
+
+func TestRandomImproveSelector(t *testing.T) {
+	selector := RandomImproveSelector{MaxInputs: 10, Rand: rand.New(rand.NewSource(1))}
+	selection, err := selector.Select(testUtxos(1, 2, 3, 4, 5, 6, 7, 8), 5, 1, FeePolicy{})
+
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, selection.Total, 5)
+	assert.LessOrEqual(t, selection.Change, 10, "Change is never more than double the amount after improving")
+}
+
+func TestConsolidateSelector(t *testing.T) {
+	selector := ConsolidateSelector{DustThreshold: 3, MaxInputs: 10}
+	selection, err := selector.Select(testUtxos(1, 20, 2, 10), 5, 1, FeePolicy{})
+
+	assert.Nil(t, err)
+	assert.Equal(t, []int{1, 2, 20}, selectedValues(selection), "Dust is swept up before topping up")
+	assert.Equal(t, 18, selection.Change)
+}
+
+func TestInsufficientFunds(t *testing.T) {
+	for name, selector := range coinSelectors {
+		_, err := selector.Select(testUtxos(1, 2), 10, 1, FeePolicy{})
+		assert.NotNil(t, err, name)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+)
+
+// Nodes find each other by gossip. A node asks each peer it connects to for the addresses it
+// knows (getaddr) and announces its own, and passes small announcements of nodes it hadn't heard
+// of on to a couple of its peers, so a new node soon becomes known across the network.
+
+// targetOutboundPeers is how many outbound connections the node keeps open
+const targetOutboundPeers = 8
+
+// connectInterval is how often the node checks its outbound connections
+const connectInterval = 10 * time.Second
+
+// maxAddrPerMessage caps the addresses sent in, or accepted from, one addr message
+const maxAddrPerMessage = 1000
+
+// An addr message of at most maxAddrRelay addresses is relayed to addrRelayPeers peers, if any
+// of its addresses were new to us and seen within addrRelayWindow
+const maxAddrRelay = 10
+const addrRelayPeers = 2
+const addrRelayWindow = 10 * time.Minute
+
+// addrBook is the node's address book, loaded when the server starts
+var addrBook = NewAddressBook("")
+
+// manageConnections connects to nodes from the address book whenever there are fewer than
+// targetOutboundPeers outbound peers, saving the address book and picking up changes to the ban
+// list as it goes, until quit is closed
+func manageConnections(bc *Blockchain, quit chan struct{}) {
+	ticker := time.NewTicker(connectInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := bans.Refresh(); err != nil {
+			fmt.Printf("Unable to read the ban list: %s\n", err)
+		}
+		disconnectBanned()
+		connectOutbound(bc)
+		if err := addrBook.Save(); err != nil {
+			fmt.Printf("Unable to save the address book: %s\n", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+func connectOutbound(bc *Blockchain) {
+	outbound := 0
+	for _, peer := range connectedPeers() {
+		if !peer.Inbound {
+			outbound++
+		}
+	}
+	if outbound >= targetOutboundPeers {
+		return
+	}
+
+	skip := func(addr string) bool {
+		host, _, _ := net.SplitHostPort(addr)
+		return addr == nodeAddress || findPeer(addr) != nil || bans.IsBanned(host)
+	}
+	for _, addr := range addrBook.Candidates(targetOutboundPeers-outbound, skip) {
+		connectToPeer(addr, bc)
+	}
+}
+
+func handleGetAddr(peer *Peer) {
+	sendAddr(peer, addrBook.Addresses(maxAddrPerMessage))
+}
+
+func handleAddr(peer *Peer, payload []byte) error {
+	var addr Addr
+	if err := decodePayload(payload, &addr); err != nil {
+		return err
+	}
+
+	if len(addr.Addresses) > maxAddrPerMessage {
+		return misbehaving(scoreMalformed, fmt.Errorf("%d addresses is more than %d", len(addr.Addresses), maxAddrPerMessage))
+	}
+
+	now := time.Now()
+	var fresh []KnownAddress
+	for _, address := range addr.Addresses {
+		if address.Addr == "" || address.Addr == nodeAddress {
+			continue
+		}
+		lastSeen := time.Unix(address.LastSeen, 0)
+		if lastSeen.After(now) {
+			lastSeen = now
+		}
+		if addrBook.Add(address.Addr, address.Services, lastSeen) && now.Sub(lastSeen) < addrRelayWindow {
+			fresh = append(fresh, KnownAddress{Addr: address.Addr, Services: address.Services, LastSeen: lastSeen})
+		}
+	}
+	fmt.Printf("Received %d addresses, %d new and recently seen\n", len(addr.Addresses), len(fresh))
+
+	if len(fresh) == 0 || len(addr.Addresses) > maxAddrRelay {
+		return nil
+	}
+	relayed := 0
+	for _, other := range activePeers() {
+		if relayed == addrRelayPeers {
+			break
+		}
+		if other != peer && other.Supports(addrVersion) {
+			sendAddr(other, fresh)
+			relayed++
+		}
+	}
+	return nil
+}
+
+func sendGetAddr(peer *Peer) {
+	peer.Send("getaddr", gobEncode(GetAddr{nodeAddress}))
+}
+
+func sendAddr(peer *Peer, addresses []KnownAddress) {
+	var netAddresses []NetAddress
+	for _, ka := range addresses {
+		netAddresses = append(netAddresses, NetAddress{ka.Addr, ka.Services, ka.LastSeen.Unix()})
+	}
+	peer.Send("addr", gobEncode(Addr{nodeAddress, netAddresses}))
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Every connection starts with a handshake: each side sends a version message describing itself
+// and acknowledges the other's with a verack. Nothing else is handled until both have been
+// exchanged. A connection speaks the lower of the two nodes' protocol versions, so a later
+// protocol extension checks peer.Supports(itsVersion) before relying on the peer understanding it.
+
+// protocolVersion is the version of the peer protocol this node speaks:
+//
+//	1 the original protocol of one unframed message per connection, without a handshake
+//	2 long lived connections that start with a version/verack handshake
+//	3 address gossip with getaddr and addr
+//	4 headers first sync with getheaders and headers
+//	5 the mempool message, asking for the transactions waiting to be mined
+//	6 ping and pong, to measure latency and spot dead connections
+const protocolVersion = 6
+
+// minProtocolVersion is the oldest protocol version a peer may speak
+const minProtocolVersion = 2
+
+// The protocol versions that introduced optional messages
+const addrVersion = 3
+const headersVersion = 4
+const mempoolVersion = 5
+const pingVersion = 6
+
+// userAgent identifies the software the node is running
+const userAgent = "/go-chain:0.2.0/"
+
+// maxClockOffset is how far a peer's clock may be from ours before we warn about it
+const maxClockOffset = 70 * time.Minute
+
+// Services a node offers its peers, as bits of Version.Services. A wallet that only hands over
+// transactions offers none.
+const (
+	serviceNetwork        uint64 = 1 << iota // serves every block of the main chain
+	serviceNetworkLimited                    // serves recent blocks, from Version.PrunedHeight up
+)
+
+// localNonce is sent in our version messages, so that a connection to ourselves can be spotted
+var localNonce = newNonce()
+
+func newNonce() uint64 {
+	data := make([]byte, 8)
+	if _, err := rand.Read(data); err != nil {
+		log.Panic(err)
+	}
+	return binary.BigEndian.Uint64(data)
+}
+
+// localServices is what this node offers, which depends on whether it has pruned old blocks
+func localServices(bc *Blockchain) uint64 {
+	if bc.IsPruned() {
+		return serviceNetworkLimited
+	}
+	return serviceNetwork | serviceNetworkLimited
+}
+
+// HandshakeDone reports whether versions and veracks have been exchanged with the peer
+func (peer *Peer) HandshakeDone() bool {
+	return peer.versionReceived && peer.verackReceived
+}
+
+// Supports reports whether the protocol version used with the peer is at least version
+func (peer *Peer) Supports(version int) bool {
+	return peer.Version >= version
+}
+
+// HasService reports whether the peer offers service
+func (peer *Peer) HasService(service uint64) bool {
+	return peer.Services&service == service
+}
+
+func handleVersion(peer *Peer, payload []byte, bc *Blockchain) error {
+	var version Version
+	if err := decodePayload(payload, &version); err != nil {
+		return err
+	}
+
+	if peer.versionReceived {
+		fmt.Printf("Ignoring a repeated version message from %s\n", peer)
+		return nil
+	}
+	if version.Nonce == localNonce {
+		fmt.Printf("Disconnecting %s, it is a connection to ourselves\n", peer)
+		peer.Disconnect()
+		return nil
+	}
+	if version.Version < minProtocolVersion {
+		fmt.Printf("Disconnecting %s, its protocol version %d is older than %d\n", peer, version.Version, minProtocolVersion)
+		peer.Disconnect()
+		return nil
+	}
+
+	peer.versionReceived = true
+	peer.Version = version.Version
+	if protocolVersion < peer.Version {
+		peer.Version = protocolVersion
+	}
+	peer.Services = version.Services
+	peer.UserAgent = version.UserAgent
+	peer.StartHeight = version.BestHeight
+	peer.BestHeight = version.BestHeight
+	peer.PrunedHeight = version.PrunedHeight
+	peer.TimeOffset = time.Unix(version.Timestamp, 0).Sub(time.Now()).Round(time.Second)
+	if peer.TimeOffset > maxClockOffset || peer.TimeOffset < -maxClockOffset {
+		fmt.Printf("WARNING: the clock of %s is %s out from ours\n", peer, peer.TimeOffset)
+	}
+
+	if peer.Addr == "" {
+		peer.setAddr(version.AddrFrom)
+	}
+	if peer.Inbound && version.AddrFrom != "" && version.Services != 0 {
+		addrBook.Add(version.AddrFrom, version.Services, time.Now())
+	}
+
+	// We told an outbound peer about ourselves when we connected, an inbound one only hears now
+	if peer.Inbound {
+		sendVersion(peer, bc)
+	}
+	peer.Send("verack", nil)
+	if peer.HandshakeDone() {
+		completeHandshake(peer, bc)
+	}
+	return nil
+}
+
+func handleVerack(peer *Peer, bc *Blockchain) {
+	if peer.verackReceived {
+		fmt.Printf("Ignoring a repeated verack from %s\n", peer)
+		return
+	}
+	peer.verackReceived = true
+	if peer.HandshakeDone() {
+		completeHandshake(peer, bc)
+	}
+}
+
+// completeHandshake swaps addresses and mempools with an outbound peer, pings the peer and asks it
+// for its headers if it is ahead of us
+func completeHandshake(peer *Peer, bc *Blockchain) {
+	fmt.Printf("Connected to %s %s (protocol %d, services %b, height %d)\n",
+		peer, peer.UserAgent, peer.Version, peer.Services, peer.StartHeight)
+
+	if !peer.Inbound {
+		addrBook.Good(peer.Addr, peer.Services)
+		if peer.Supports(addrVersion) {
+			// Ask for more nodes to connect to, and say we're listening so the peer can pass us on
+			sendGetAddr(peer)
+			self := KnownAddress{Addr: nodeAddress, Services: localServices(bc), LastSeen: time.Now()}
+			sendAddr(peer, []KnownAddress{self})
+		}
+		// A node that is behind asks once it has caught up, as it can't check the transactions yet
+		if peer.Supports(mempoolVersion) && peer.StartHeight <= bc.GetBestHeight() {
+			sendMempool(peer)
+		}
+	}
+
+	// Measure the peer's latency straight away rather than at the first regular ping
+	if peer.Supports(pingVersion) {
+		sendPing(peer)
+	}
+	if peer.Supports(headersVersion) && peer.HasService(serviceNetworkLimited) && peer.StartHeight > downloads.bestHeight(bc) {
+		sendGetHeaders(peer, downloads.locator(bc), nil)
+	}
+}
+
+func sendVersion(peer *Peer, bc *Blockchain) {
+	version := Version{
+		Version:      protocolVersion,
+		Services:     localServices(bc),
+		UserAgent:    userAgent,
+		Nonce:        localNonce,
+		Timestamp:    time.Now().Unix(),
+		BestHeight:   bc.GetBestHeight(),
+		PrunedHeight: bc.GetPruneState().PrunedHeight,
+		AddrFrom:     nodeAddress,
+	}
+	peer.Send("version", gobEncode(version))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	_, addresses := newTestWallets(1)
+	bc := CreateBlockchainInStore(addresses[0], NewMemoryStore(), false)
+
+	// connect returns an inbound peer on one end of a pipe and the other end, as the remote node
+	connect := func() (*Peer, net.Conn) {
+		local, remote := net.Pipe()
+		peer := newPeer(local, "", true)
+		go peer.writeLoop()
+		return peer, remote
+	}
+	versionFrom := func(version Version) []byte {
+		version.Timestamp = 1700000000
+		version.AddrFrom = "localhost:3999"
+		return gobEncode(version)
+	}
+
+	peer, remote := connect()
+	handleVersion(peer, versionFrom(Version{Version: protocolVersion + 1, Services: serviceNetwork | serviceNetworkLimited, Nonce: 1}), bc)
+	for _, expected := range []string{"version", "verack"} {
+		command, _, err := readMessage(remote)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, command, "An inbound peer is answered with our version and a verack")
+	}
+	assert.Equal(t, protocolVersion, peer.Version, "The connection uses the older of the two protocols")
+	assert.True(t, peer.HasService(serviceNetwork))
+	assert.Equal(t, "localhost:3999", peer.Addr)
+	assert.False(t, peer.HandshakeDone())
+	handleVerack(peer, bc)
+	assert.True(t, peer.HandshakeDone())
+	assert.True(t, peer.Supports(protocolVersion))
+	assert.False(t, peer.Supports(protocolVersion+1))
+	peer.Disconnect()
+
+	peer, remote = connect()
+	handleVersion(peer, versionFrom(Version{Version: minProtocolVersion - 1, Nonce: 1}), bc)
+	assert.True(t, peer.disconnecting(), "Peers speaking an older protocol are rejected")
+	assert.False(t, peer.versionReceived)
+	remote.Close()
+
+	peer, remote = connect()
+	handleVersion(peer, versionFrom(Version{Version: protocolVersion, Nonce: localNonce}), bc)
+	assert.True(t, peer.disconnecting(), "A connection to ourselves is dropped")
+	remote.Close()
+}
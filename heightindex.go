@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"log"
+)
+
+// The height index maps the height of every block on the main chain to its hash, and is kept up
+// to date by connectBlock and disconnectBlock.
+
+// buildHeightIndex (re)creates the height index by walking back from the tip
+func buildHeightIndex(tx ChainTx) error {
+	if err := tx.DeleteHeights(); err != nil {
+		return err
+	}
+
+	hash := tx.GetTip()
+	for len(hash) > 0 {
+		header := tx.GetHeader(hash)
+		if header == nil {
+			return errors.New("main chain is missing blocks, unable to index heights")
+		}
+		if err := tx.PutHashAtHeight(header.Height, header.Hash); err != nil {
+			return err
+		}
+		hash = header.PrevBlockHash
+	}
+	return nil
+}
+
+// ReindexHeights rebuilds the height index from the main chain
+func (blockchain *Blockchain) ReindexHeights() {
+	err := blockchain.db.Update(buildHeightIndex)
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// GetBlockHashByHeight returns the hash of the main chain block at height
+func (blockchain *Blockchain) GetBlockHashByHeight(height int) ([]byte, error) {
+	var hash []byte
+	err := blockchain.db.View(func(tx ChainTx) error {
+		if hash = tx.GetHashAtHeight(height); hash == nil {
+			return errors.New("No block at that height")
+		}
+		return nil
+	})
+	return hash, err
+}
+
+// GetBlockByHeight returns the main chain block at height
+func (blockchain *Blockchain) GetBlockByHeight(height int) (Block, error) {
+	hash, err := blockchain.GetBlockHashByHeight(height)
+	if err != nil {
+		return Block{}, err
+	}
+	return blockchain.GetBlock(hash)
+}
+
+// GetBlockHashesInRange returns the hashes of the main chain blocks from height from up to and
+// including height to, in height order. The range is clipped to the blocks that exist.
+func (blockchain *Blockchain) GetBlockHashesInRange(from, to int) [][]byte {
+	var hashes [][]byte
+	err := blockchain.db.View(func(tx ChainTx) error {
+		hashes = tx.GetHashesInRange(from, to)
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return hashes
+}
+
+// GetBlocksInRange returns the main chain blocks from height from up to and including height to
+func (blockchain *Blockchain) GetBlocksInRange(from, to int) []*Block {
+	var blocks []*Block
+	for _, hash := range blockchain.GetBlockHashesInRange(from, to) {
+		block, err := blockchain.GetBlock(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		blocks = append(blocks, &block)
+	}
+	return blocks
+}
+
+// HeightIterator walks the main chain forwards (towards the tip) from a given height
+type HeightIterator struct {
+	height     int
+	blockchain *Blockchain
+}
+
+func (blockchain *Blockchain) IteratorFrom(height int) *HeightIterator {
+	return &HeightIterator{height, blockchain}
+}
+
+// Next returns the next block, or nil once past the tip
+func (iterator *HeightIterator) Next() *Block {
+	block, err := iterator.blockchain.GetBlockByHeight(iterator.height)
+	if err != nil {
+		return nil
+	}
+	iterator.height++
+	return &block
+}
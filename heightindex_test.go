@@ -0,0 +1,91 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// addTestBranch mines n blocks paying miner on top of parent and adds them to bc
+func addTestBranch(bc *Blockchain, miner string, parent *Block, n int) []*Block {
+	var branch []*Block
+	for i := 0; i < n; i++ {
+		parent = newTestBlock(miner, parent)
+		bc.AddBlock(parent)
+		branch = append(branch, parent)
+	}
+	return branch
+}
+
+func assertHeightsIndexed(t *testing.T, bc *Blockchain, blocks ...*Block) {
+	for _, block := range blocks {
+		hash, err := bc.GetBlockHashByHeight(block.Height)
+		assert.Nil(t, err)
+		assert.Equal(t, block.Hash, hash, "Height %d maps to the main chain block", block.Height)
+	}
+}
+
+func TestHeightIndex(t *testing.T) {
+	_, addresses := newTestWallets(1)
+	bc := newTestBlockchain(t, addresses[0], false)
+	genesis := tipBlock(t, bc)
+	blocks := append([]*Block{genesis}, addTestBranch(bc, addresses[0], genesis, 3)...)
+
+	assertHeightsIndexed(t, bc, blocks...)
+	_, err := bc.GetBlockHashByHeight(4)
+	assert.NotNil(t, err)
+	block, err := bc.GetBlockByHeight(2)
+	assert.Nil(t, err)
+	assert.Equal(t, blocks[2].Hash, block.Hash)
+
+	assert.Equal(t, [][]byte{blocks[1].Hash, blocks[2].Hash}, bc.GetBlockHashesInRange(1, 2))
+	assert.Len(t, bc.GetBlockHashesInRange(-5, 100), 4, "The range is clipped to the chain")
+	assert.Equal(t, [][]byte{blocks[3].Hash, blocks[2].Hash, blocks[1].Hash, genesis.Hash}, bc.GetBlockHashes())
+	inRange := bc.GetBlocksInRange(2, 3)
+	assert.Len(t, inRange, 2)
+	assert.Equal(t, blocks[3].Hash, inRange[1].Hash)
+
+	iterator := bc.IteratorFrom(1)
+	for _, expected := range blocks[1:] {
+		assert.Equal(t, expected.Hash, iterator.Next().Hash)
+	}
+	assert.Nil(t, iterator.Next(), "The iterator stops past the tip")
+}
+
+func TestHeightIndexFollowsReorg(t *testing.T) {
+	_, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := newTestBlockchain(t, alice, false)
+	genesis := tipBlock(t, bc)
+
+	branchA := addTestBranch(bc, alice, genesis, 2)
+	assertHeightsIndexed(t, bc, branchA...)
+
+	// Bob's branch only takes over once it is longer, then replaces every height of Alice's
+	branchB := addTestBranch(bc, bob, genesis, 2)
+	assertHeightsIndexed(t, bc, branchA...)
+	branchB = append(branchB, addTestBranch(bc, bob, branchB[1], 1)...)
+	assert.Equal(t, branchB[2].Hash, bc.tip)
+	assertHeightsIndexed(t, bc, branchB...)
+	assert.Len(t, bc.GetBlockHashesInRange(0, 10), 4)
+}
+
+func TestHeightIndexIsBuiltForOldChains(t *testing.T) {
+	_, addresses := newTestWallets(1)
+	bc := newTestBlockchain(t, addresses[0], false)
+	blocks := addTestBranch(bc, addresses[0], tipBlock(t, bc), 2)
+
+	// Chains from before the height index have no heights
+	err := bc.db.Update(func(tx ChainTx) error {
+		return tx.DeleteHeights()
+	})
+	assert.Nil(t, err)
+	setSchemaVersion(bc.db, 1)
+	bc.db.Close()
+
+	bc = NewBlockchain(testNodeID)
+	defer bc.db.Close()
+	assertHeightsIndexed(t, bc, blocks...)
+
+	bc.ReindexHeights()
+	assertHeightsIndexed(t, bc, blocks...)
+}
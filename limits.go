@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"time"
+)
+
+// A node limits what any one peer can make it spend. Messages are limited in size by type (see
+// maxPayloads), the bytes read from and written to each peer are rate limited, a peer's send queue
+// is bounded and the number of inbound connections, in all and from one host, is capped. Together
+// these bound the memory and goroutines a peer, or a host opening many connections, can tie up.
+
+// maxInboundPeers caps the connections other nodes (and wallets) open to us
+const maxInboundPeers = 117
+
+// maxInboundPerHost caps the inbound connections from one host. Connections from our own machine
+// aren't capped, as every local node shares its host.
+const maxInboundPerHost = 4
+
+// Each peer may send us, and be sent, peerByteRate bytes a second on average, in bursts of up to
+// peerByteBurst. A peer sending faster is simply read more slowly.
+const peerByteRate = 4 * 1024 * 1024
+const peerByteBurst = 4 * 1024 * 1024
+
+// checkInbound returns an error if a new connection from host would break the inbound limits
+func checkInbound(host string) error {
+	inbound, fromHost := 0, 0
+	for _, peer := range connectedPeers() {
+		if peer.Inbound {
+			inbound++
+			if peer.Host() == host {
+				fromHost++
+			}
+		}
+	}
+	if inbound >= maxInboundPeers {
+		return fmt.Errorf("there are already %d inbound connections", inbound)
+	}
+	if ip := net.ParseIP(host); (ip == nil || !ip.IsLoopback()) && fromHost >= maxInboundPerHost {
+		return fmt.Errorf("there are already %d connections from %s", fromHost, host)
+	}
+	return nil
+}
+
+// rateLimiter is a token bucket of bytes
+type rateLimiter struct {
+	rate   float64 // bytes a second
+	burst  float64
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+func newRateLimiter(rate, burst int) *rateLimiter {
+	return &rateLimiter{rate: float64(rate), burst: float64(burst), tokens: float64(burst), last: time.Now()}
+}
+
+// wait takes n bytes from the bucket, blocking until it is no longer in debt. A message larger
+// than the burst just waits longer, so a message of any size gets through.
+func (limiter *rateLimiter) wait(n int) {
+	limiter.mutex.Lock()
+	now := time.Now()
+	limiter.tokens = limiter.tokens + now.Sub(limiter.last).Seconds()*limiter.rate
+	if limiter.tokens > limiter.burst {
+		limiter.tokens = limiter.burst
+	}
+	limiter.last = now
+	limiter.tokens = limiter.tokens - float64(n)
+	var delay time.Duration
+	if limiter.tokens < 0 {
+		delay = time.Duration(-limiter.tokens / limiter.rate * float64(time.Second))
+	}
+	limiter.mutex.Unlock()
+
+	time.Sleep(delay)
+}
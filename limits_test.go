@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	limiter := newRateLimiter(10000, 1000)
+	start := time.Now()
+	limiter.wait(1000)
+	assert.Less(t, time.Since(start), 50*time.Millisecond, "A burst passes straight away")
+	limiter.wait(2000)
+	assert.GreaterOrEqual(t, time.Since(start), 150*time.Millisecond, "Then bytes pass at the rate")
+}
+
+func TestPeerLimits(t *testing.T) {
+	peer := newSyncPeer(0)
+	defer peer.Disconnect()
+	peer.Inbound = true
+	assert.Equal(t, "pipe", peer.Host())
+	for i := 1; i < maxInboundPerHost; i++ {
+		other := newSyncPeer(0)
+		other.Inbound = true
+		defer other.Disconnect()
+	}
+	assert.ErrorContains(t, checkInbound("pipe"), "connections from pipe")
+	assert.Nil(t, checkInbound("10.0.0.1"))
+	assert.Nil(t, checkInbound("127.0.0.1"), "Local connections aren't capped by host")
+
+	// Nothing reads from the peer, so its queue fills up and it is dropped rather than blocking
+	for i := 0; i < peerQueueLen; i++ {
+		assert.True(t, peer.Send("verack", nil))
+	}
+	assert.False(t, peer.Send("verack", nil))
+	assert.True(t, peer.disconnecting())
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"log"
+)
+
+// A block locator says compactly where a node's chain is: the hashes of its tip and the nine
+// blocks before it, then of blocks further and further back (the step doubling each time) down
+// to genesis. A peer finds the first hash in the locator that is on its own main chain, which is
+// the last block the two chains share, and answers with a batch of the blocks after it, up to an
+// optional stop hash.
+
+// locatorDenseLen is how many of the latest blocks are all included before the steps start doubling
+const locatorDenseLen = 10
+
+// locatorHeights returns the heights of the blocks in a locator for a chain with tip at height
+func locatorHeights(height int) []int {
+	var heights []int
+	step := 1
+	for {
+		heights = append(heights, height)
+		if height == 0 {
+			return heights
+		}
+		if len(heights) >= locatorDenseLen {
+			step = step * 2
+		}
+		height = height - step
+		if height < 0 {
+			height = 0
+		}
+	}
+}
+
+// BlockLocator returns a locator for the main chain
+func (blockchain *Blockchain) BlockLocator() [][]byte {
+	var locator [][]byte
+	err := blockchain.db.View(func(tx ChainTx) error {
+		tip := tx.GetHeader(tx.GetTip()).Height
+		for _, height := range locatorHeights(tip) {
+			locator = append(locator, tx.GetHashAtHeight(height))
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return locator
+}
+
+// FindForkHeight returns the height of the first block in locator that is on our main chain,
+// or -1 if there is none (the locator is for another chain)
+func (blockchain *Blockchain) FindForkHeight(locator [][]byte) int {
+	fork := -1
+	err := blockchain.db.View(func(tx ChainTx) error {
+		for _, hash := range locator {
+			header := tx.GetHeader(hash)
+			if header == nil {
+				continue
+			}
+			if bytes.Equal(tx.GetHashAtHeight(header.Height), hash) {
+				fork = header.Height
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return fork
+}
+
+// LocateHeaders returns the headers of the main chain blocks after the fork point with locator,
+// oldest first. The batch ends at the block with stopHash (nil for no stop hash) or after max
+// headers, whichever comes first.
+func (blockchain *Blockchain) LocateHeaders(locator [][]byte, stopHash []byte, max int) []BlockHeader {
+	var result []BlockHeader
+	fork := blockchain.FindForkHeight(locator)
+	err := blockchain.db.View(func(tx ChainTx) error {
+		for next := fork + 1; len(result) < max; next++ {
+			hash := tx.GetHashAtHeight(next)
+			if hash == nil {
+				return nil
+			}
+			result = append(result, *tx.GetHeader(hash))
+			if bytes.Equal(hash, stopHash) {
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return result
+}
+
+// LocateBlocks is LocateHeaders for just the block hashes
+func (blockchain *Blockchain) LocateBlocks(locator [][]byte, stopHash []byte, max int) [][]byte {
+	var hashes [][]byte
+	for _, header := range blockchain.LocateHeaders(locator, stopHash, max) {
+		hashes = append(hashes, header.Hash)
+	}
+	return hashes
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestLocatorHeights(t *testing.T) {
+	assert.Equal(t, []int{0}, locatorHeights(0))
+	assert.Equal(t, []int{5, 4, 3, 2, 1, 0}, locatorHeights(5))
+	assert.Equal(t, []int{100, 99, 98, 97, 96, 95, 94, 93, 92, 91, 89, 85, 77, 61, 29, 0}, locatorHeights(100),
+		"The ten latest blocks, then steps doubling back to genesis")
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"math/big"
+)
+
+// messagePrefix domain-separates signed messages from transactions. Transaction inputs are signed
+// over the SHA256 of a trimmed transaction's hashData, which is always longer than a hash, whereas
+// messages are hashed twice behind this prefix, so asking the wallet to sign a message can never
+// produce a valid input signature (or vice versa).
+const messagePrefix = "go-chain Signed Message:\n"
+
+// coordinateLen is the size of each of r, s, x and y for P-256
+const coordinateLen = 32
+
+// hashMessage is the digest that is actually signed for a message
+func hashMessage(message string) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString(messagePrefix)
+	buffer.Write(Int64ToBytes(int64(len(message))))
+	buffer.WriteString(message)
+
+	firstHash := sha256.Sum256(buffer.Bytes())
+	secondHash := sha256.Sum256(firstHash[:])
+	return secondHash[:]
+}
+
+// SignMessage proves ownership of the wallet's address by signing message with its key.
+// The signature embeds the public key (so the verifier can check it hashes to the address)
+// and is returned base64 encoded.
+func (wallet *Wallet) SignMessage(message string) (string, error) {
+	r, s, err := ecdsa.Sign(rand.Reader, &wallet.PrivateKey, hashMessage(message))
+	if err != nil {
+		return "", err
+	}
+
+	publicKey := wallet.PrivateKey.PublicKey
+	signature := bytes.Join([][]byte{
+		padCoordinate(r),
+		padCoordinate(s),
+		padCoordinate(publicKey.X),
+		padCoordinate(publicKey.Y),
+	}, []byte{})
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// VerifyMessage checks signature was made over message by the key behind address
+func VerifyMessage(address, signature, message string) (bool, error) {
+	if !ValidateAddress(address) {
+		return false, errors.New("invalid address")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return false, err
+	}
+	if len(sig) != 4*coordinateLen {
+		return false, errors.New("malformed signature")
+	}
+
+	r := new(big.Int).SetBytes(sig[:coordinateLen])
+	s := new(big.Int).SetBytes(sig[coordinateLen : 2*coordinateLen])
+	x := new(big.Int).SetBytes(sig[2*coordinateLen : 3*coordinateLen])
+	y := new(big.Int).SetBytes(sig[3*coordinateLen:])
+
+	curve := elliptic.P256()
+	if !curve.IsOnCurve(x, y) {
+		return false, errors.New("malformed signature")
+	}
+
+	// The key must be the one the address was derived from, using the same encoding as Wallet.PublicKey
+	pubKeyBytes := append(padCoordinate(x), padCoordinate(y)...)
+	if !bytes.Equal(HashPubKey(pubKeyBytes), ConvertBase58AddressToPubKeyHash(address)) {
+		return false, nil
+	}
+
+	pubKey := ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+	return ecdsa.Verify(&pubKey, hashMessage(message), r, s), nil
+}
+
+func padCoordinate(i *big.Int) []byte {
+	padded := make([]byte, coordinateLen)
+	return i.FillBytes(padded)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestSignAndVerifyMessage(t *testing.T) {
+	wallet := NewWalletData().GetWallet()
+	address := string(wallet.GetAddress())
+
+	signature, err := wallet.SignMessage("I own this address")
+	assert.Nil(t, err)
+
+	valid, err := VerifyMessage(address, signature, "I own this address")
+	assert.Nil(t, err)
+	assert.True(t, valid, "Signature verifies against the signing address")
+
+	valid, _ = VerifyMessage(address, signature, "I own that address")
+	assert.False(t, valid, "Signature does not verify a different message")
+
+	other := NewWalletData().GetWallet()
+	valid, _ = VerifyMessage(string(other.GetAddress()), signature, "I own this address")
+	assert.False(t, valid, "Signature does not verify for a different address")
+}
+
+func TestVerifyMessageRejectsMalformedSignature(t *testing.T) {
+	address := string(NewWalletData().GetWallet().GetAddress())
+
+	_, err := VerifyMessage(address, "c2hvcnQ=", "message")
+	assert.NotNil(t, err)
+}
+
+func TestVerifyMessageWithShortCoordinate(t *testing.T) {
+	// About 1 key in 128 has an X or Y with a leading zero byte, which must still be padded
+	wallet := NewWalletData()
+	for wallet.PublicKeyX.BitLen() > 8*(coordinateLen-1) && wallet.PublicKeyY.BitLen() > 8*(coordinateLen-1) {
+		wallet = NewWalletData()
+	}
+	signer := wallet.GetWallet()
+
+	signature, err := signer.SignMessage("short")
+	assert.Nil(t, err)
+	valid, err := VerifyMessage(string(signer.GetAddress()), signature, "short")
+	assert.Nil(t, err)
+	assert.True(t, valid)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+// A peer that breaks the protocol is given a misbehavior score, more for what can only be
+// deliberate (a block that fails validation) than for what might be a bug or a race (a malformed
+// message, headers we can't connect). A peer whose score reaches banThreshold is disconnected and
+// its host banned for defaultBanDuration. Peers on our own machine are only disconnected, as
+// banning their host would ban every local node.
+
+// banThreshold is the misbehavior score at which a peer is banned
+const banThreshold = 100
+
+// Misbehavior scores
+const (
+	scoreMalformed   = 20  // a message that doesn't decode or breaks the limits of its type
+	scoreUnconnected = 20  // headers that don't follow a block we know
+	scoreInvalid     = 100 // an invalid block or header chain
+	scoreInvalidTx   = 10  // a transaction that fails validation
+)
+
+// maxInventoryItems caps the items accepted in one inventory
+const maxInventoryItems = 1000
+
+// maxLocatorLen caps the hashes accepted in a block locator. A locator for a chain of any
+// realistic length needs far fewer.
+const maxLocatorLen = 101
+
+// hashLen is the length of a block or transaction hash
+const hashLen = 32
+
+// bans is the node's ban list, loaded when the server starts
+var bans = NewBanList("")
+
+// misbehavior is an error a handler returns for a protocol violation by the peer
+type misbehavior struct {
+	score int
+	err   error
+}
+
+func (m *misbehavior) Error() string {
+	return m.err.Error()
+}
+
+func (m *misbehavior) Unwrap() error {
+	return m.err
+}
+
+func misbehaving(score int, err error) error {
+	return &misbehavior{score, err}
+}
+
+// decodePayload decodes a message payload, treating a payload that doesn't decode as misbehavior
+func decodePayload(payload []byte, v interface{}) error {
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(v); err != nil {
+		return misbehaving(scoreMalformed, fmt.Errorf("malformed payload: %w", err))
+	}
+	return nil
+}
+
+// checkHashes checks a list of hashes from a peer is no longer than max and holds only hashes
+func checkHashes(hashes [][]byte, max int) error {
+	if len(hashes) > max {
+		return misbehaving(scoreMalformed, fmt.Errorf("%d hashes is more than %d", len(hashes), max))
+	}
+	for _, hash := range hashes {
+		if len(hash) != hashLen {
+			return misbehaving(scoreMalformed, fmt.Errorf("a hash of %d bytes", len(hash)))
+		}
+	}
+	return nil
+}
+
+// handlerFailed deals with an error returned by the handler of a message from peer, scoring the
+// peer if it misbehaved
+func handlerFailed(peer *Peer, command string, err error) {
+	var m *misbehavior
+	if !errors.As(err, &m) {
+		fmt.Printf("Unable to handle [%s] from %s: %s\n", command, peer, err)
+		return
+	}
+	peer.Misbehaving(m.score, fmt.Sprintf("[%s] %s", command, m.err))
+}
+
+// Misbehaving adds to the peer's misbehavior score, banning it once the score reaches banThreshold
+func (peer *Peer) Misbehaving(score int, reason string) {
+	peer.BanScore = peer.BanScore + score
+	fmt.Printf("Misbehavior by %s (score %d, now %d): %s\n", peer, score, peer.BanScore, reason)
+	if peer.BanScore < banThreshold {
+		return
+	}
+
+	host := peer.Host()
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		fmt.Printf("Disconnecting %s, not banning it as it is local\n", peer)
+	} else {
+		fmt.Printf("Banning %s for %s\n", host, defaultBanDuration)
+		bans.Ban(host, time.Now().Add(defaultBanDuration))
+		if err := bans.Save(); err != nil {
+			fmt.Printf("Unable to save the ban list: %s\n", err)
+		}
+	}
+	peer.Disconnect()
+}
+
+// Host returns the host the peer is connected from, which is what is banned
+func (peer *Peer) Host() string {
+	host, _, err := net.SplitHostPort(peer.conn.RemoteAddr().String())
+	if err != nil {
+		return peer.conn.RemoteAddr().String()
+	}
+	return host
+}
+
+// disconnectBanned disconnects the peers whose hosts are banned, after the ban list changes
+func disconnectBanned() {
+	for _, peer := range connectedPeers() {
+		if bans.IsBanned(peer.Host()) {
+			fmt.Printf("Disconnecting %s, its host is banned\n", peer)
+			peer.Disconnect()
+		}
+	}
+}
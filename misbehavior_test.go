@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+func TestMisbehavior(t *testing.T) {
+	_, addresses := newTestWallets(1)
+	bc := CreateBlockchainInStore(addresses[0], NewMemoryStore(), false)
+	bans = NewBanList(filepath.Join(t.TempDir(), "banlist.dat"))
+	defer func() { bans = NewBanList("") }()
+
+	peer := newSyncPeer(0)
+	defer peer.Disconnect()
+
+	// Malformed messages are scored rather than crashing the node
+	handleMessage(peer, "inventory", []byte("not gob"), bc)
+	assert.Equal(t, scoreMalformed, peer.BanScore)
+	handleMessage(peer, "getheaders", gobEncode(GetHeaders{Locator: [][]byte{{1, 2, 3}}}), bc)
+	assert.Equal(t, 2*scoreMalformed, peer.BanScore, "Hashes must be hashes")
+	assert.False(t, peer.disconnecting())
+
+	// A transaction that spends nothing we know of may just be early, so it isn't held against the peer
+	tx := Transaction{Inputs: []TxInput{{TxOutputID: make([]byte, hashLen), Signature: []byte{1}, PubKey: []byte{1}}}, Outputs: []TxOutput{{Value: 1}}}
+	tx.SetId()
+	handleMessage(peer, "txdata", gobEncode(TxData{"", tx.Serialize()}), bc)
+	assert.Equal(t, 2*scoreMalformed, peer.BanScore)
+
+	handleMessage(peer, "headers", gobEncode(Headers{Headers: []BlockHeader{{Hash: []byte{1}, PrevBlockHash: bc.BlockLocator()[0], Height: 1}}}), bc)
+	assert.True(t, peer.disconnecting(), "An invalid header chain gets the peer banned")
+	assert.True(t, bans.IsBanned(peer.Host()))
+	assert.Len(t, bans.Bans(), 1)
+
+	// Peers on our own machine are only disconnected
+	listener, err := net.Listen(protocol, "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer listener.Close()
+	go net.Dial(protocol, listener.Addr().String())
+	conn, err := listener.Accept()
+	assert.Nil(t, err)
+	local := newPeer(conn, "", true)
+	go local.writeLoop()
+	local.Misbehaving(banThreshold, "testing")
+	assert.True(t, local.disconnecting())
+	assert.False(t, bans.IsBanned(local.Host()))
+	assert.Len(t, bans.Bans(), 1)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Payment is a single recipient of a transaction
+type Payment struct {
+	To     string `json:"to"`
+	Amount int    `json:"amount"`
+}
+
+// PaymentsTotal is the amount of coins needed to make all the payments
+func PaymentsTotal(payments []Payment) int {
+	total := 0
+	for _, payment := range payments {
+		total = total + payment.Amount
+	}
+	return total
+}
+
+// ValidatePayments checks every payment goes to a valid address and is for a positive amount
+func ValidatePayments(payments []Payment) error {
+	if len(payments) == 0 {
+		return errors.New("no payments given")
+	}
+	for i, payment := range payments {
+		if !ValidateAddress(payment.To) {
+			return fmt.Errorf("payment %d: invalid address %q", i+1, payment.To)
+		}
+		if payment.Amount <= 0 {
+			return fmt.Errorf("payment %d: amount must be positive, got %d", i+1, payment.Amount)
+		}
+	}
+	return nil
+}
+
+// NewPayments pairs up recipient addresses with amounts (the nth address is paid the nth amount)
+func NewPayments(addresses []string, amounts []int) ([]Payment, error) {
+	if len(addresses) != len(amounts) {
+		return nil, fmt.Errorf("%d recipients but %d amounts", len(addresses), len(amounts))
+	}
+	var payments []Payment
+	for i, address := range addresses {
+		payments = append(payments, Payment{address, amounts[i]})
+	}
+	return payments, nil
+}
+
+// LoadPayments reads a list of payments from a .json file (an array of {"to", "amount"} objects)
+// or otherwise from CSV with one "address,amount" pair per line. A CSV header line is skipped.
+func LoadPayments(path string) ([]Payment, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		var payments []Payment
+		if err := json.NewDecoder(file).Decode(&payments); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return payments, nil
+	}
+	return readPaymentsCSV(file)
+}
+
+func readPaymentsCSV(r io.Reader) ([]Payment, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var payments []Payment
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		amount, err := strconv.Atoi(strings.TrimSpace(record[1]))
+		if err != nil {
+			if line == 1 {
+				continue // header
+			}
+			return nil, fmt.Errorf("line %d: invalid amount %q", line, record[1])
+		}
+		payments = append(payments, Payment{strings.TrimSpace(record[0]), amount})
+	}
+	return payments, nil
+}
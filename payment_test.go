@@ -0,0 +1,36 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestReadPaymentsCSV(t *testing.T) {
+	payments, err := readPaymentsCSV(strings.NewReader("address,amount\n1abc, 5\n1def,7\n"))
+
+	assert.Nil(t, err)
+	assert.Equal(t, []Payment{{"1abc", 5}, {"1def", 7}}, payments, "Header is skipped and pairs are parsed")
+	assert.Equal(t, 12, PaymentsTotal(payments))
+
+	_, err = readPaymentsCSV(strings.NewReader("1abc,5\n1def,seven\n"))
+	assert.NotNil(t, err, "Bad amounts after the first line are rejected")
+}
+
+func TestNewPaymentsRequiresPairs(t *testing.T) {
+	_, err := NewPayments([]string{"1abc", "1def"}, []int{5})
+	assert.NotNil(t, err)
+}
+
+func TestValidatePaymentsChecksAddresses(t *testing.T) {
+	address := string(NewWalletData().GetWallet().GetAddress())
+	assert.Nil(t, ValidatePayments([]Payment{{address, 5}}))
+
+	// Payloads with a valid checksum that aren't a versioned pubkey hash
+	short := []byte{addressGenerationVersion, 1, 2, 3}
+	otherVersion := append([]byte{0x6f}, make([]byte, pubKeyHashLen)...)
+	for _, payload := range [][]byte{short, otherVersion} {
+		garbage := string(Base58Encode(append(payload, checksum(payload)...)))
+		assert.NotNil(t, ValidatePayments([]Payment{{garbage, 5}}))
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// peerQueueLen is how many messages can wait to be written to a peer. A peer whose queue fills
+// up, or holds more than maxQueuedBytes, isn't keeping up with what it is sent and is dropped.
+const peerQueueLen = 5000
+const maxQueuedBytes = 2 * maxMessagePayload
+
+// peerReadTimeout is how long a peer may go without sending a message before it is dropped
+const peerReadTimeout = 20 * time.Minute
+
+// peerWriteTimeout is how long writing one message may take before the peer is dropped
+const peerWriteTimeout = time.Minute
+
+// peerDialTimeout is how long connecting to a peer may take
+const peerDialTimeout = 10 * time.Second
+
+type queuedMessage struct {
+	command string
+	payload []byte
+}
+
+// Peer is a long lived connection to another node. A read loop hands each incoming message to
+// the server, and a write loop sends the messages queued with Send, so a slow peer only holds up
+// its own goroutines.
+type Peer struct {
+	conn    net.Conn
+	Addr    string // the address the peer listens on (learnt from its version message if it connected to us)
+	Inbound bool
+
+	// Learnt from the peer's version message
+	Version      int // the protocol version used on this connection, the lower of ours and the peer's
+	Services     uint64
+	UserAgent    string
+	StartHeight  int
+	BestHeight   int // the best height the peer is known to have, updated as it sends headers
+	PrunedHeight int
+	TimeOffset   time.Duration // how far the peer's clock is ahead of ours
+
+	versionReceived bool
+	verackReceived  bool
+
+	knownInventory *inventorySet // blocks and transactions the peer is known to have
+	BanScore       int           // how badly the peer has misbehaved, it is banned at banThreshold
+
+	ConnectedAt time.Time
+	PingTime    time.Duration // the round trip time of the last ping answered
+	MinPing     time.Duration
+	pingNonce   uint64    // the nonce of the ping waiting for a pong, or 0
+	pingSent    time.Time // when the last ping was sent
+
+	queue       chan queuedMessage
+	queuedBytes atomic.Int64 // the size of the payloads in queue
+	sendLimit   *rateLimiter
+	recvLimit   *rateLimiter
+	quit        chan struct{} // closed to start disconnecting
+	done        chan struct{} // closed once the connection is closed
+	closeOnce   sync.Once
+}
+
+func newPeer(conn net.Conn, addr string, inbound bool) *Peer {
+	return &Peer{
+		conn:           conn,
+		Addr:           addr,
+		Inbound:        inbound,
+		knownInventory: newInventorySet(maxKnownInventory),
+		ConnectedAt:    time.Now(),
+		queue:          make(chan queuedMessage, peerQueueLen),
+		sendLimit:      newRateLimiter(peerByteRate, peerByteBurst),
+		recvLimit:      newRateLimiter(peerByteRate, peerByteBurst),
+		quit:           make(chan struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+func (peer *Peer) String() string {
+	peersMutex.Lock()
+	addr := peer.Addr
+	peersMutex.Unlock()
+	if addr != "" {
+		return addr
+	}
+	return peer.conn.RemoteAddr().String()
+}
+
+// setAddr records the address an inbound peer listens on. Addr is only written holding both
+// handlerMutex and peersMutex, so either is enough to read it.
+func (peer *Peer) setAddr(addr string) {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	peer.Addr = addr
+}
+
+// start runs the peer's read and write loops, calling handle for each message received
+func (peer *Peer) start(handle func(peer *Peer, command string, payload []byte)) {
+	go peer.writeLoop()
+	go peer.readLoop(handle)
+}
+
+func (peer *Peer) readLoop(handle func(peer *Peer, command string, payload []byte)) {
+	reader := bufio.NewReader(peer.conn)
+	for {
+		peer.conn.SetReadDeadline(time.Now().Add(peerReadTimeout))
+		command, payload, err := readMessage(reader)
+		if err != nil {
+			if err != io.EOF && !peer.disconnecting() {
+				fmt.Printf("Dropping %s: %s\n", peer, err)
+			}
+			peer.Disconnect()
+			return
+		}
+		peer.recvLimit.wait(messageHeaderLen + len(payload))
+		handle(peer, command, payload)
+	}
+}
+
+func (peer *Peer) writeLoop() {
+	defer close(peer.done)
+	defer peer.conn.Close()
+
+	for {
+		select {
+		case message := <-peer.queue:
+			if err := peer.write(message); err != nil {
+				fmt.Printf("Dropping %s: %s\n", peer, err)
+				peer.Disconnect()
+				return
+			}
+		case <-peer.quit:
+			// Flush whatever was queued before the disconnect, so a final reply isn't lost
+			for {
+				select {
+				case message := <-peer.queue:
+					if peer.write(message) != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (peer *Peer) write(message queuedMessage) error {
+	peer.queuedBytes.Add(-int64(len(message.payload)))
+	peer.sendLimit.wait(messageHeaderLen + len(message.payload))
+	peer.conn.SetWriteDeadline(time.Now().Add(peerWriteTimeout))
+	return writeMessage(peer.conn, message.command, message.payload)
+}
+
+// Send queues a message for the peer, returning false if the peer is disconnecting. It never
+// blocks, a peer that has too much queued already is dropped instead.
+func (peer *Peer) Send(command string, payload []byte) bool {
+	if peer.disconnecting() {
+		return false
+	}
+	size := int64(len(payload))
+	if peer.queuedBytes.Add(size) <= maxQueuedBytes {
+		select {
+		case peer.queue <- queuedMessage{command, payload}:
+			return true
+		default:
+		}
+	}
+	peer.queuedBytes.Add(-size)
+	fmt.Printf("Dropping %s, it isn't keeping up with the messages sent to it\n", peer)
+	peer.Disconnect()
+	return false
+}
+
+// Disconnect closes the connection once the messages already queued have been written
+func (peer *Peer) Disconnect() {
+	peer.closeOnce.Do(func() {
+		close(peer.quit)
+		removePeer(peer)
+	})
+}
+
+func (peer *Peer) disconnecting() bool {
+	select {
+	case <-peer.quit:
+		return true
+	default:
+		return false
+	}
+}
+
+// The connected peers
+var peers = make(map[*Peer]bool)
+var peersMutex sync.Mutex
+
+func addPeer(peer *Peer) {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	peers[peer] = true
+}
+
+func removePeer(peer *Peer) {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	delete(peers, peer)
+}
+
+func connectedPeers() []*Peer {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	var connected []*Peer
+	for peer := range peers {
+		connected = append(connected, peer)
+	}
+	return connected
+}
+
+// activePeers returns the connected peers that have completed the handshake
+func activePeers() []*Peer {
+	var active []*Peer
+	for _, peer := range connectedPeers() {
+		if peer.HandshakeDone() {
+			active = append(active, peer)
+		}
+	}
+	return active
+}
+
+// findPeer returns the connected peer listening on addr, or nil
+func findPeer(addr string) *Peer {
+	peersMutex.Lock()
+	defer peersMutex.Unlock()
+	for peer := range peers {
+		if peer.Addr == addr {
+			return peer
+		}
+	}
+	return nil
+}
+
+// dialPeer opens an outbound connection to the node listening on addr
+func dialPeer(addr string, handle func(peer *Peer, command string, payload []byte)) (*Peer, error) {
+	conn, err := net.DialTimeout(protocol, addr, peerDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	peer := newPeer(conn, addr, false)
+	addPeer(peer)
+	peer.start(handle)
+	return peer, nil
+}
+
+// disconnectAll disconnects every peer and waits (up to timeout) for their queues to be flushed
+func disconnectAll(timeout time.Duration) {
+	deadline := time.After(timeout)
+	for _, peer := range connectedPeers() {
+		peer.Disconnect()
+		select {
+		case <-peer.done:
+		case <-deadline:
+			return
+		}
+	}
+}
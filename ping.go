@@ -0,0 +1,168 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Every peer is pinged now and then with a random nonce, and must answer with a pong carrying the
+// same nonce. The time the answer takes is the peer's latency, and a peer that doesn't answer in
+// time is dropped, so a connection that has silently died doesn't go on counting as a peer. What
+// the node knows about its peers is written to a file as it goes, for getpeerinfo.
+
+// pingInterval is how often each peer is pinged
+const pingInterval = 2 * time.Minute
+
+// pingTimeout is how long a peer has to answer a ping before it is dropped
+const pingTimeout = 5 * time.Minute
+
+// peerCheckInterval is how often pings are sent and checked, and the peer info file written
+const peerCheckInterval = 10 * time.Second
+
+// peerInfoFile is where a running node describes its peers
+const peerInfoFile = "peerinfo_%s.dat"
+
+// PeerInfo describes a connected peer
+type PeerInfo struct {
+	Addr        string        `json:"addr"`
+	Inbound     bool          `json:"inbound"`
+	Version     int           `json:"version"`
+	Services    uint64        `json:"services"`
+	UserAgent   string        `json:"useragent"`
+	StartHeight int           `json:"startheight"`
+	BestHeight  int           `json:"bestheight"`
+	BanScore    int           `json:"banscore"`
+	ConnectedAt time.Time     `json:"conntime"`
+	PingTime    time.Duration `json:"pingtime"` // the round trip time of the last ping answered
+	MinPing     time.Duration `json:"minping"`
+	PingWait    time.Duration `json:"pingwait,omitempty"` // how long an unanswered ping has been waiting
+}
+
+// PeerInfoFile is what a running node last wrote about its peers
+type PeerInfoFile struct {
+	Updated time.Time
+	Peers   []PeerInfo
+}
+
+// watchPeers pings peers, drops those that don't answer and writes the peer info file at path,
+// until quit is closed. The file is removed when the node stops.
+func watchPeers(path string, quit chan struct{}) {
+	ticker := time.NewTicker(peerCheckInterval)
+	defer ticker.Stop()
+	defer os.Remove(path)
+
+	for {
+		handlerMutex.Lock()
+		checkPings(time.Now())
+		info := PeerInfoFile{time.Now(), describePeers()}
+		handlerMutex.Unlock()
+
+		if err := writePeerInfo(path, info); err != nil {
+			fmt.Printf("Unable to write the peer info: %s\n", err)
+		}
+		select {
+		case <-ticker.C:
+		case <-quit:
+			return
+		}
+	}
+}
+
+// checkPings drops peers that haven't answered a ping within pingTimeout, and pings those due one
+func checkPings(now time.Time) {
+	for _, peer := range activePeers() {
+		if !peer.Supports(pingVersion) {
+			continue
+		}
+		if peer.pingNonce != 0 && now.Sub(peer.pingSent) > pingTimeout {
+			fmt.Printf("Disconnecting %s, it didn't answer a ping within %s\n", peer, pingTimeout)
+			peer.Disconnect()
+		} else if peer.pingNonce == 0 && now.Sub(peer.pingSent) >= pingInterval {
+			sendPing(peer)
+		}
+	}
+}
+
+func describePeers() []PeerInfo {
+	var infos []PeerInfo
+	for _, peer := range activePeers() {
+		info := PeerInfo{
+			Addr:        peer.String(),
+			Inbound:     peer.Inbound,
+			Version:     peer.Version,
+			Services:    peer.Services,
+			UserAgent:   peer.UserAgent,
+			StartHeight: peer.StartHeight,
+			BestHeight:  peer.BestHeight,
+			BanScore:    peer.BanScore,
+			ConnectedAt: peer.ConnectedAt,
+			PingTime:    peer.PingTime,
+			MinPing:     peer.MinPing,
+		}
+		if peer.pingNonce != 0 {
+			info.PingWait = time.Since(peer.pingSent)
+		}
+		infos = append(infos, info)
+	}
+	return infos
+}
+
+func writePeerInfo(path string, info PeerInfoFile) error {
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(info); err != nil {
+		return err
+	}
+	return os.WriteFile(path, buffer.Bytes(), 0644)
+}
+
+// ReadPeerInfo reads the peer info a running node wrote to path
+func ReadPeerInfo(path string) (PeerInfoFile, error) {
+	var info PeerInfoFile
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return info, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(data)).Decode(&info)
+	return info, err
+}
+
+func handlePing(peer *Peer, payload []byte) error {
+	var ping Ping
+	if err := decodePayload(payload, &ping); err != nil {
+		return err
+	}
+	peer.Send("pong", gobEncode(Pong{nodeAddress, ping.Nonce}))
+	return nil
+}
+
+func handlePong(peer *Peer, payload []byte) error {
+	var pong Pong
+	if err := decodePayload(payload, &pong); err != nil {
+		return err
+	}
+	if pong.Nonce == 0 {
+		return misbehaving(scoreMalformed, errors.New("pong without a nonce"))
+	}
+	if peer.pingNonce == 0 || pong.Nonce != peer.pingNonce {
+		// A pong for a ping that timed out, or one we didn't send, tells us nothing
+		fmt.Printf("Ignoring an unexpected pong from %s\n", peer)
+		return nil
+	}
+
+	peer.pingNonce = 0
+	peer.PingTime = time.Since(peer.pingSent)
+	if peer.MinPing == 0 || peer.PingTime < peer.MinPing {
+		peer.MinPing = peer.PingTime
+	}
+	return nil
+}
+
+func sendPing(peer *Peer) {
+	peer.pingNonce = newNonce()
+	peer.pingSent = time.Now()
+	peer.Send("ping", gobEncode(Ping{nodeAddress, peer.pingNonce}))
+}
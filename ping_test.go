@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"github.com/stretchr/testify/assert"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPing(t *testing.T) {
+	peer := newSyncPeer(0)
+	defer peer.Disconnect()
+	nextMessage := func(command string, v interface{}) {
+		message := <-peer.queue
+		assert.Equal(t, command, message.command)
+		assert.Nil(t, gob.NewDecoder(bytes.NewReader(message.payload)).Decode(v))
+	}
+
+	checkPings(time.Now())
+	var ping Ping
+	nextMessage("ping", &ping)
+	assert.NotZero(t, ping.Nonce)
+	checkPings(time.Now())
+	assert.Empty(t, peer.queue, "One ping at a time")
+
+	assert.Nil(t, handlePong(peer, gobEncode(Pong{"", ping.Nonce + 1})))
+	assert.Zero(t, peer.PingTime, "A pong must answer our ping")
+	assert.Nil(t, handlePong(peer, gobEncode(Pong{"", ping.Nonce})))
+	assert.Greater(t, peer.PingTime, time.Duration(0))
+	assert.Equal(t, peer.PingTime, peer.MinPing)
+	assert.Zero(t, peer.pingNonce)
+
+	// Peers answer our pings with the same nonce
+	assert.Nil(t, handlePing(peer, gobEncode(Ping{"", 42})))
+	var pong Pong
+	nextMessage("pong", &pong)
+	assert.Equal(t, uint64(42), pong.Nonce)
+
+	path := filepath.Join(t.TempDir(), "peerinfo.dat")
+	assert.Nil(t, writePeerInfo(path, PeerInfoFile{time.Now(), describePeers()}))
+	info, err := ReadPeerInfo(path)
+	assert.Nil(t, err)
+	assert.Len(t, info.Peers, 1)
+	assert.Equal(t, peer.PingTime, info.Peers[0].PingTime)
+
+	// A peer that doesn't answer is dropped
+	checkPings(time.Now().Add(pingInterval))
+	nextMessage("ping", &ping)
+	assert.False(t, peer.disconnecting())
+	checkPings(time.Now().Add(pingTimeout + time.Second))
+	assert.True(t, peer.disconnecting())
+}
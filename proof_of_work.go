@@ -16,20 +16,31 @@ type ProofOfWork struct {
 }
 
 func (pow *ProofOfWork) prepareData(nonce int) []byte {
+	return powData(pow.block.Timestamp, pow.block.HashTransactions(), pow.block.PrevBlockHash, nonce)
+}
+
+// powData is the data hashed for the proof of work. It only needs what is in a block's header,
+// so headers can be checked before their blocks are downloaded.
+func powData(timestamp int64, merkleRoot, prevBlockHash []byte, nonce int) []byte {
 	data := bytes.Join([][]byte{
-		Int64ToBytes(pow.block.Timestamp),
-		pow.block.HashTransactions(),
-		pow.block.PrevBlockHash,
+		Int64ToBytes(timestamp),
+		merkleRoot,
+		prevBlockHash,
 		Int64ToBytes(int64(nonce)),
 		Int64ToBytes(int64(difficulty)),
 	}, []byte{})
 	return data
 }
 
-func NewProofOfWork(block *Block) *ProofOfWork {
+// powTarget is the value a block hash must be below
+func powTarget() *big.Int {
 	target := big.NewInt(1)
 	target.Lsh(target, 256-difficulty)
-	return &ProofOfWork{block, target}
+	return target
+}
+
+func NewProofOfWork(block *Block) *ProofOfWork {
+	return &ProofOfWork{block, powTarget()}
 }
 
 func (pow *ProofOfWork) Run() (nonce int, solvedHash []byte) {
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// A pruned chain keeps every header, the UTXO set and the most recent blocks, but deletes the
+// bodies of older blocks. Once blocks are gone the UTXO set can no longer be rebuilt from the
+// chain, so it is only ever updated block by block.
+
+// minBlocksToKeep stops a small target from pruning blocks that a reorganisation could still need
+var minBlocksToKeep = 10
+
+// PruneState is the persisted pruning configuration and progress of a chain
+type PruneState struct {
+	PrunedHeight int   // bodies of main chain blocks below this height have been deleted
+	KeepBlocks   int   // keep (at least) this many of the latest blocks, 0 for no limit
+	TargetSize   int64 // keep at most this many bytes of blocks, 0 for no limit
+}
+
+// Enabled reports whether the chain is configured to prune
+func (state PruneState) Enabled() bool {
+	return state.KeepBlocks > 0 || state.TargetSize > 0
+}
+
+func (state PruneState) Serialize() []byte {
+	var buff bytes.Buffer
+	encoder := gob.NewEncoder(&buff)
+	if err := encoder.Encode(state); err != nil {
+		log.Panic(err)
+	}
+	return buff.Bytes()
+}
+
+func DeserializePruneState(data []byte) PruneState {
+	var state PruneState
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&state); err != nil {
+		log.Panic(err)
+	}
+	return state
+}
+
+func (blockchain *Blockchain) GetPruneState() PruneState {
+	var state PruneState
+	err := blockchain.db.View(func(tx ChainTx) error {
+		state = tx.GetPruneState()
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return state
+}
+
+// IsPruned reports whether any block bodies have been deleted
+func (blockchain *Blockchain) IsPruned() bool {
+	return blockchain.GetPruneState().PrunedHeight > 0
+}
+
+// HasBlock reports whether the block is known, even if its body has since been pruned
+func (blockchain *Blockchain) HasBlock(blockHash []byte) bool {
+	found := false
+	err := blockchain.db.View(func(tx ChainTx) error {
+		found = tx.GetHeader(blockHash) != nil
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return found
+}
+
+// HasBlockData reports whether the full block (not just its header) is stored
+func (blockchain *Blockchain) HasBlockData(blockHash []byte) bool {
+	found := false
+	err := blockchain.db.View(func(tx ChainTx) error {
+		found = tx.GetBlockSize(blockHash) > 0
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return found
+}
+
+// SetPruneTarget turns on pruning, keeping keepBlocks blocks and/or targetSize bytes of blocks.
+// Passing zero for both turns pruning off again (blocks already deleted stay deleted).
+func (blockchain *Blockchain) SetPruneTarget(keepBlocks int, targetSize int64) error {
+	if keepBlocks < 0 || targetSize < 0 {
+		return errors.New("prune targets can't be negative")
+	}
+	if keepBlocks > 0 && keepBlocks < minBlocksToKeep {
+		return fmt.Errorf("must keep at least %d blocks", minBlocksToKeep)
+	}
+	return blockchain.db.Update(func(tx ChainTx) error {
+		state := tx.GetPruneState()
+		state.KeepBlocks = keepBlocks
+		state.TargetSize = targetSize
+		return tx.PutPruneState(state)
+	})
+}
+
+// Prune deletes the bodies of old main chain blocks until the prune target is met, returning how
+// many were deleted. Transactions in pruned blocks are removed from the transaction index.
+func (blockchain *Blockchain) Prune() (int, error) {
+	pruned := 0
+	err := blockchain.db.Update(func(tx ChainTx) error {
+		state := tx.GetPruneState()
+		if !state.Enabled() {
+			return nil
+		}
+
+		bestHeight := tx.GetHeader(tx.GetTip()).Height
+
+		keepFrom := 0
+		if state.KeepBlocks > 0 {
+			keepFrom = bestHeight - state.KeepBlocks + 1
+		}
+		if state.TargetSize > 0 {
+			var size int64
+			for height := bestHeight; height >= state.PrunedHeight; height-- {
+				hash := tx.GetHashAtHeight(height)
+				if hash == nil {
+					break
+				}
+				size = size + int64(tx.GetBlockSize(hash))
+				if size > state.TargetSize {
+					if height+1 > keepFrom {
+						keepFrom = height + 1
+					}
+					break
+				}
+			}
+		}
+		if keepFrom > bestHeight-minBlocksToKeep+1 {
+			keepFrom = bestHeight - minBlocksToKeep + 1
+		}
+
+		for height := state.PrunedHeight; height < keepFrom; height++ {
+			hash := tx.GetHashAtHeight(height)
+			if hash == nil {
+				return errors.New("height index is incomplete, unable to prune")
+			}
+			block := tx.GetBlock(hash)
+			if block == nil {
+				continue
+			}
+			if tx.HasTxIndex() {
+				if err := unindexBlockTxs(tx, block); err != nil {
+					return err
+				}
+			}
+			if err := tx.DeleteBlock(hash); err != nil {
+				return err
+			}
+			pruned++
+		}
+
+		if keepFrom > state.PrunedHeight {
+			state.PrunedHeight = keepFrom
+		}
+		return tx.PutPruneState(state)
+	})
+	return pruned, err
+}
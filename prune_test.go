@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPruneKeepsHeadersAndUtxos(t *testing.T) {
+	defer func(keep int) { minBlocksToKeep = keep }(minBlocksToKeep)
+	minBlocksToKeep = 3 // mining is slow, keep the chain short
+
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := CreateBlockchainInStore(alice, NewMemoryStore(), true)
+	utxoSet := UTXOSet{bc}
+	utxoSet.Reindex()
+	genesis := bc.tip
+
+	for height := 1; height <= 5; height++ {
+		bc.MineBlock([]*Transaction{NewCoinbaseTx(bob, fmt.Sprintf("block %d", height))})
+	}
+
+	assert.NotNil(t, bc.SetPruneTarget(2, 0), "Must keep enough blocks for a reorganisation")
+	assert.Nil(t, bc.SetPruneTarget(minBlocksToKeep, 0))
+	pruned, err := bc.Prune()
+	assert.Nil(t, err)
+	assert.Equal(t, 3, pruned, "Heights 0 to 2 are pruned")
+	assert.True(t, bc.IsPruned())
+	assert.False(t, bc.HasBlockData(genesis))
+	assert.True(t, bc.HasBlock(genesis), "The header is kept")
+
+	_, err = bc.FindTx(NewCoinbaseTx(bob, "block 1").ID)
+	assert.NotNil(t, err, "Transactions in pruned blocks are gone")
+
+	// The genesis reward can still be spent, its output comes from the UTXO set
+	tx := NewUtxoTransaction(wallets, alice, []Payment{{bob, 4}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	assert.True(t, bc.VerifyTransaction(tx))
+	mineTx(bc, bob, tx)
+	assert.Equal(t, 4+6*10, balanceOf(utxoSet, bob))
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Transactions spread by announcement. A node that accepts a transaction into its mempool sends
+// its ID in an inventory to every peer not already known to have it, and a peer that hasn't seen
+// it asks for it with getdata. Each peer remembers the inventory it has exchanged with us, so a
+// transaction isn't announced back to where it came from. A node that has just connected asks
+// its peer for the transactions already waiting to be mined with a mempool message.
+
+// maxKnownInventory caps the inventory remembered for each peer, the oldest is forgotten first
+const maxKnownInventory = 5000
+
+// maxTxsPerInventory caps the transaction IDs sent in one inventory
+const maxTxsPerInventory = 500
+
+// txRequestTimeout is how long to wait for a transaction asked for from one peer before asking
+// another peer that announces it
+const txRequestTimeout = time.Minute
+
+// maxRejectedTxs caps the transactions remembered as rejected, so they aren't fetched again
+const maxRejectedTxs = 1000
+
+// maxMempoolTxs caps the transactions waiting to be mined, and maxTxRequests those asked for
+const maxMempoolTxs = 5000
+const maxTxRequests = 5000
+
+var errMempoolFull = errors.New("the mempool is full")
+
+// inventorySet is a set of hashes that forgets the oldest once it holds more than max
+type inventorySet struct {
+	max   int
+	items map[string]bool
+	order []string
+}
+
+func newInventorySet(max int) *inventorySet {
+	return &inventorySet{max: max, items: make(map[string]bool)}
+}
+
+func (set *inventorySet) Add(hash []byte) {
+	key := hex.EncodeToString(hash)
+	if set.items[key] {
+		return
+	}
+	set.items[key] = true
+	set.order = append(set.order, key)
+	if len(set.order) > set.max {
+		delete(set.items, set.order[0])
+		set.order = set.order[1:]
+	}
+}
+
+func (set *inventorySet) Has(hash []byte) bool {
+	return set.items[hex.EncodeToString(hash)]
+}
+
+// The transactions asked for and not yet received, and those that weren't accepted. Like the
+// mempool they are guarded by handlerMutex.
+var txRequests = make(map[string]time.Time)
+var rejectedTxs = newInventorySet(maxRejectedTxs)
+
+// acceptTransaction validates a transaction and adds it to the mempool. It must spend outputs in
+// the UTXO set with valid signatures, and none already spent by a transaction in the mempool.
+// A transaction that could never be valid is the sender's misbehavior.
+func acceptTransaction(tx *Transaction, bc *Blockchain) error {
+	txID := hex.EncodeToString(tx.ID)
+	if _, found := mempool[txID]; found {
+		return fmt.Errorf("transaction %s is already in the mempool", txID)
+	}
+	if len(mempool) >= maxMempoolTxs {
+		return errMempoolFull
+	}
+	if tx.IsCoinbase() {
+		return misbehaving(scoreInvalidTx, fmt.Errorf("transaction %s is a coinbase", txID))
+	}
+	if err := bc.checkTransaction(tx, nil); errors.Is(err, errUnknownPrevTx) || errors.Is(err, errSpentOutput) {
+		return err // we may be behind, or another transaction spending the same output got here first
+	} else if err != nil {
+		return misbehaving(scoreInvalidTx, err)
+	}
+
+	spent := make(map[string]bool)
+	for _, pending := range mempool {
+		for _, input := range pending.Inputs {
+			spent[hex.EncodeToString(outpointKey(input.TxOutputID, input.TxOutputIndex))] = true
+		}
+	}
+	if !spendsUnspentOutputs(UTXOSet{bc}, tx, spent) {
+		return fmt.Errorf("transaction %s spends an output that is already spent", txID)
+	}
+
+	mempool[txID] = *tx
+	return nil
+}
+
+// removeFromMempool drops the transactions of a connected block, and any others that spend the
+// same outputs
+func removeFromMempool(block *Block) {
+	spent := make(map[string]bool)
+	for _, tx := range block.Transactions {
+		delete(mempool, hex.EncodeToString(tx.ID))
+		for _, input := range tx.Inputs {
+			spent[hex.EncodeToString(outpointKey(input.TxOutputID, input.TxOutputIndex))] = true
+		}
+	}
+	for txID, tx := range mempool {
+		for _, input := range tx.Inputs {
+			if spent[hex.EncodeToString(outpointKey(input.TxOutputID, input.TxOutputIndex))] {
+				delete(mempool, txID)
+				break
+			}
+		}
+	}
+}
+
+// relayTransaction announces a transaction to every peer that isn't known to have it
+func relayTransaction(tx *Transaction) {
+	for _, peer := range activePeers() {
+		if !peer.knownInventory.Has(tx.ID) {
+			peer.knownInventory.Add(tx.ID)
+			sendInventory(peer, "tx", [][]byte{tx.ID})
+		}
+	}
+}
+
+// requestTransactions asks peer for the announced transactions we haven't seen, unless they are
+// already on their way from another peer
+func requestTransactions(peer *Peer, txIDs [][]byte) {
+	now := time.Now()
+	for _, txID := range txIDs {
+		peer.knownInventory.Add(txID)
+		key := hex.EncodeToString(txID)
+		if _, found := mempool[key]; found || rejectedTxs.Has(txID) {
+			continue
+		}
+		if requested, found := txRequests[key]; found && now.Sub(requested) < txRequestTimeout {
+			continue
+		}
+		if len(txRequests) >= maxTxRequests {
+			expireTxRequests(now)
+			if len(txRequests) >= maxTxRequests {
+				return
+			}
+		}
+		txRequests[key] = now
+		sendGetData(peer, "tx", txID)
+	}
+}
+
+// expireTxRequests forgets the requests that have timed out
+func expireTxRequests(now time.Time) {
+	for key, requested := range txRequests {
+		if now.Sub(requested) >= txRequestTimeout {
+			delete(txRequests, key)
+		}
+	}
+}
+
+func handleMempool(peer *Peer) {
+	var txIDs [][]byte
+	for _, tx := range mempool {
+		peer.knownInventory.Add(tx.ID)
+		txIDs = append(txIDs, tx.ID)
+		if len(txIDs) == maxTxsPerInventory {
+			sendInventory(peer, "tx", txIDs)
+			txIDs = nil
+		}
+	}
+	if len(txIDs) > 0 {
+		sendInventory(peer, "tx", txIDs)
+	}
+}
+
+func handleTxData(peer *Peer, payload []byte, bc *Blockchain) error {
+	var txdata TxData
+	if err := decodePayload(payload, &txdata); err != nil {
+		return err
+	}
+	var tx Transaction
+	if err := decodePayload(txdata.Transaction, &tx); err != nil {
+		return err
+	}
+
+	peer.knownInventory.Add(tx.ID)
+	delete(txRequests, hex.EncodeToString(tx.ID))
+	if _, found := mempool[hex.EncodeToString(tx.ID)]; found {
+		return nil
+	}
+	if err := acceptTransaction(&tx, bc); err != nil {
+		// One spending outputs we don't know of yet may be fine once we have synced, and one that
+		// didn't fit in the mempool once it has room
+		if !errors.Is(err, errUnknownPrevTx) && !errors.Is(err, errMempoolFull) {
+			rejectedTxs.Add(tx.ID)
+		}
+		return err
+	}
+	fmt.Printf("Added transaction %x to the mempool\n", tx.ID)
+
+	relayTransaction(&tx)
+	if len(miningAddress) > 0 && len(mempool) > 2 {
+		mineTransactions(bc)
+	}
+	return nil
+}
+
+// requestMempools asks every peer for its mempool, once we have caught up with the chain
+func requestMempools() {
+	for _, peer := range activePeers() {
+		if peer.Supports(mempoolVersion) {
+			sendMempool(peer)
+		}
+	}
+}
+
+func sendMempool(peer *Peer) {
+	peer.Send("mempool", gobEncode(Mempool{nodeAddress}))
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestTransactionRelay(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := CreateBlockchainInStore(alice, NewMemoryStore(), false)
+	utxoSet := UTXOSet{bc}
+	utxoSet.Reindex()
+	defer func() { mempool = make(map[string]Transaction) }()
+
+	tx := NewUtxoTransaction(wallets, alice, []Payment{{bob, 3}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	doubleSpend := NewUtxoTransaction(wallets, alice, []Payment{{bob, 4}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+
+	origin, other := newSyncPeer(0), newSyncPeer(0)
+	defer origin.Disconnect()
+	defer other.Disconnect()
+
+	// An announced transaction is asked for from one peer at a time
+	requestTransactions(origin, [][]byte{tx.ID})
+	requestTransactions(other, [][]byte{tx.ID})
+	assert.Len(t, origin.queue, 1)
+	assert.Empty(t, other.queue, "A transaction on its way isn't asked for again")
+	<-origin.queue
+
+	handleTxData(origin, gobEncode(TxData{"", tx.Serialize()}), bc)
+	assert.Contains(t, mempool, hex.EncodeToString(tx.ID))
+	assert.Empty(t, origin.queue, "A transaction isn't announced back to where it came from")
+	assert.Empty(t, other.queue, "Nor to a peer that announced it")
+
+	third := newSyncPeer(0)
+	defer third.Disconnect()
+	relayTransaction(tx)
+	assert.Len(t, third.queue, 1, "Other peers hear of it")
+	message := <-third.queue
+	assert.Equal(t, "inventory", message.command)
+
+	handleTxData(origin, gobEncode(TxData{"", doubleSpend.Serialize()}), bc)
+	assert.NotContains(t, mempool, hex.EncodeToString(doubleSpend.ID), "Double spends of the mempool are rejected")
+	requestTransactions(other, [][]byte{doubleSpend.ID})
+	assert.Empty(t, other.queue, "Rejected transactions aren't fetched again")
+
+	// A new peer is told what is waiting to be mined
+	handleMempool(third)
+	message = <-third.queue
+	var inv Inventory
+	assert.Nil(t, gob.NewDecoder(bytes.NewReader(message.payload)).Decode(&inv))
+	assert.Equal(t, [][]byte{tx.ID}, inv.Items)
+
+	block := mineTx(bc, alice, tx)
+	removeFromMempool(block)
+	assert.Empty(t, mempool, "Mined transactions leave the mempool")
+}
+
+func TestMiningDropsTransactionsThatCantBeMined(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := CreateBlockchainInStore(alice, NewMemoryStore(), false)
+	utxoSet := UTXOSet{bc}
+	utxoSet.Reindex()
+	miningAddress = alice
+	defer func() { miningAddress, mempool = "", make(map[string]Transaction) }()
+
+	tx := NewUtxoTransaction(wallets, alice, []Payment{{bob, 3}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+
+	// One whose parent has gone (say, disconnected by a reorganisation) and one with a bad signature
+	orphan := *tx
+	orphan.Inputs = []TxInput{tx.Inputs[0]}
+	orphan.Inputs[0].TxOutputID = make([]byte, hashLen)
+	orphan.SetId()
+	forged := *tx
+	forged.Inputs = []TxInput{tx.Inputs[0]}
+	forged.Inputs[0].Signature = append([]byte{}, tx.Inputs[0].Signature...)
+	forged.Inputs[0].Signature[0] ^= 0xff
+	forged.SetId()
+	mempool = map[string]Transaction{
+		hex.EncodeToString(tx.ID):     *tx,
+		hex.EncodeToString(orphan.ID): orphan,
+		hex.EncodeToString(forged.ID): forged,
+	}
+
+	mineTransactions(bc)
+	assert.Equal(t, 1, bc.GetBestHeight())
+	assert.Equal(t, 3, balanceOf(utxoSet, bob))
+	assert.Empty(t, mempool, "Transactions that can't be mined are dropped")
+}
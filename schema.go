@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// The chain database records the version of its layout in the metadata bucket. Every change to
+// the buckets or how they are encoded gets a migration here, and NewBlockchain runs the ones a
+// database is missing, oldest first, after backing it up. Databases written before versioning
+// have no metadata bucket and count as version 0.
+
+type migration struct {
+	version     int // the schema version the migration brings the database up to
+	description string
+	migrate     func(blockchain *Blockchain) error
+}
+
+// migrations must stay in version order. Each one must cope with databases that were partly
+// upgraded by the ad hoc checks used before versioning, so they test before they rebuild.
+var migrations = []migration{
+	{1, "store block headers", func(blockchain *Blockchain) error {
+		return blockchain.db.Update(func(tx ChainTx) error {
+			if tx.GetHeader(tx.GetTip()) != nil {
+				return nil
+			}
+			return buildHeaders(tx)
+		})
+	}},
+	{2, "index blocks by height", func(blockchain *Blockchain) error {
+		return blockchain.db.Update(func(tx ChainTx) error {
+			if tx.GetHashAtHeight(0) != nil {
+				return nil
+			}
+			return buildHeightIndex(tx)
+		})
+	}},
+	{3, "key the chainstate by outpoint and index it by address", func(blockchain *Blockchain) error {
+		// Rebuilding is the only way to tell an old chainstate from a new one. Pruning came
+		// later, so a pruned chain already has the current layout (and can't be rebuilt).
+		if !blockchain.IsPruned() {
+			UTXOSet{blockchain}.Reindex()
+		}
+		return nil
+	}},
+	{4, "hash transactions in a fixed encoding", func(blockchain *Blockchain) error {
+		// Transaction IDs used to be the hash of their gob encoding. Every block hash and
+		// outpoint depends on them, so an old chain can't be rewritten in place.
+		block, err := blockchain.GetBlock(blockchain.tip)
+		if err != nil {
+			return err
+		}
+		for _, tx := range block.Transactions {
+			if !bytes.Equal(tx.ID, tx.Hash()) {
+				return errors.New("the chain was written with gob transaction hashes; delete the database and create or resync the chain")
+			}
+		}
+		return nil
+	}},
+}
+
+func currentSchemaVersion() int {
+	return migrations[len(migrations)-1].version
+}
+
+func schemaVersion(db ChainStore) int {
+	version := 0
+	err := db.View(func(tx ChainTx) error {
+		version = tx.GetSchemaVersion()
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return version
+}
+
+// CheckSchemaVersion returns an error if db was written by a newer version of go-chain
+func CheckSchemaVersion(db ChainStore) error {
+	if version := schemaVersion(db); version > currentSchemaVersion() {
+		return fmt.Errorf("the blockchain database has schema version %d, but this go-chain only understands up to version %d; upgrade go-chain to open it",
+			version, currentSchemaVersion())
+	}
+	return nil
+}
+
+// migrate brings the database up to the current schema version
+func (blockchain *Blockchain) migrate() error {
+	if err := CheckSchemaVersion(blockchain.db); err != nil {
+		return err
+	}
+	version := schemaVersion(blockchain.db)
+	if version == currentSchemaVersion() {
+		return nil
+	}
+
+	if store, ok := blockchain.db.(BackupStore); ok {
+		path, err := store.Backup(fmt.Sprintf(".v%d.bak", version))
+		if err != nil {
+			return fmt.Errorf("unable to back up the database before migrating it: %s", err)
+		}
+		fmt.Printf("Backed up the blockchain database to %s\n", path)
+	}
+
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		fmt.Printf("Upgrading the blockchain database to version %d: %s\n", m.version, m.description)
+		if err := m.migrate(blockchain); err != nil {
+			return fmt.Errorf("migration to version %d failed: %s", m.version, err)
+		}
+		err := blockchain.db.Update(func(tx ChainTx) error {
+			return tx.PutSchemaVersion(m.version)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
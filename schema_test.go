@@ -0,0 +1,59 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func setSchemaVersion(db ChainStore, version int) {
+	db.Update(func(tx ChainTx) error {
+		return tx.PutSchemaVersion(version)
+	})
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	_, addresses := newTestWallets(1)
+	db := NewMemoryStore()
+	bc := CreateBlockchainInStore(addresses[0], db, true)
+	UTXOSet{bc}.Reindex()
+	assert.Equal(t, currentSchemaVersion(), schemaVersion(db), "New chains start at the current version")
+
+	// A database from before the height index existed is upgraded when it is opened
+	setSchemaVersion(db, 1)
+	db.Update(func(tx ChainTx) error {
+		return tx.DeleteHeights()
+	})
+	bc = NewBlockchainFromStore(db)
+	assert.Equal(t, currentSchemaVersion(), schemaVersion(db))
+	hash, err := bc.GetBlockHashByHeight(0)
+	assert.Nil(t, err)
+	assert.Equal(t, bc.tip, hash)
+
+	setSchemaVersion(db, currentSchemaVersion()+1)
+	assert.ErrorContains(t, CheckSchemaVersion(db), "upgrade go-chain")
+	assert.Panics(t, func() { NewBlockchainFromStore(db) }, "A newer database isn't touched")
+}
+
+func TestSchemaMigrationRefusesGobHashedChains(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	db := NewMemoryStore()
+	bc := CreateBlockchainInStore(alice, db, true)
+	utxoSet := UTXOSet{bc}
+	utxoSet.Reindex()
+	mineTx(bc, alice, NewUtxoTransaction(wallets, alice, []Payment{{bob, 3}}, &utxoSet, LargestFirstSelector{}, FeePolicy{}))
+	setSchemaVersion(db, 3)
+	assert.Nil(t, bc.migrate(), "Signed transactions hash to their IDs too")
+
+	// Stand in for a block written before transactions had a fixed encoding
+	block := tipBlock(t, bc)
+	block.Transactions[0].ID = make([]byte, txIDLen)
+	db.Update(func(tx ChainTx) error {
+		return tx.PutBlock(block)
+	})
+	setSchemaVersion(db, 3)
+
+	assert.ErrorContains(t, bc.migrate(), "delete the database")
+	assert.Equal(t, 3, schemaVersion(db), "The database isn't marked as upgraded")
+	assert.Panics(t, func() { NewBlockchainFromStore(db) })
+}
@@ -1,22 +1,44 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/gob"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 )
 
 type Version struct {
-	Version    int
-	BestHeight int
-	AddrFrom   string
+	Version      int    // the newest protocol version the node speaks
+	Services     uint64 // what the node offers its peers
+	UserAgent    string
+	Nonce        uint64 // random for each run of a node, to detect connecting to ourselves
+	Timestamp    int64
+	BestHeight   int
+	PrunedHeight int // the node only serves blocks from this height up
+	AddrFrom     string
 }
 type GetBlocks struct {
 	AddrFrom string
+	Locator  [][]byte // where the requester's chain is
+	StopHash []byte   // the last block wanted, or nil for as many as fit in a batch
+}
+type GetHeaders struct {
+	AddrFrom string
+	Locator  [][]byte
+	StopHash []byte
+}
+type Headers struct {
+	AddrFrom string
+	Headers  []BlockHeader
 }
 type Inventory struct {
 	AddrFrom string
@@ -36,332 +58,473 @@ type TxData struct {
 	AddrFrom    string
 	Transaction []byte
 }
+type Mempool struct {
+	AddrFrom string
+}
+type Ping struct {
+	AddrFrom string
+	Nonce    uint64
+}
+type Pong struct {
+	AddrFrom string
+	Nonce    uint64 // the nonce of the ping being answered
+}
+type GetAddr struct {
+	AddrFrom string
+}
+type Addr struct {
+	AddrFrom  string
+	Addresses []NetAddress
+}
+type NetAddress struct {
+	Addr     string
+	Services uint64
+	LastSeen int64
+}
 
-const nodeVersion = 1
 const protocol = "tcp"
 const commandLength = 12
 
-var nodeAddress string
-var knownNodes = []string{"localhost:3000"}
-var blocksInTransit = [][]byte{}
+// maxBlocksPerInventory caps the block hashes sent in answer to one getblocks
+const maxBlocksPerInventory = 500
+
+var nodeAddress string   // the address we advertise to other nodes
 var miningAddress string // only set on mining nodes
 var mempool = make(map[string]Transaction)
 
-func StartServer(nodeID, minerAddress string) {
-	nodeAddress = fmt.Sprintf("localhost:%s", nodeID)
+// handlerMutex lets one message be handled at a time, so handlers can share the chain, the
+// mempool and the download state without their own locking
+var handlerMutex sync.Mutex
+
+// shutdownTimeout is how long to wait for queued messages to be flushed when the node stops
+const shutdownTimeout = 5 * time.Second
+
+// defaultSeedNode is the seed used when none are configured, and where wallets send transactions
+const defaultSeedNode = "localhost:3000"
+
+// NodeConfig is where a node listens and which nodes it starts from
+type NodeConfig struct {
+	BindAddress     string   // the address to listen on
+	ExternalAddress string   // the address other nodes can reach us on, advertised to peers
+	Seeds           []string // added to the address book so a new node has somewhere to start
+}
+
+// NewNodeConfig checks the addresses given and fills in the defaults: listening on
+// localhost:<nodeID>, advertising the bind address and starting from defaultSeedNode
+func NewNodeConfig(nodeID, bindAddress, externalAddress string, seeds []string) (NodeConfig, error) {
+	if bindAddress == "" {
+		bindAddress = net.JoinHostPort("localhost", nodeID)
+	}
+	host, port, err := net.SplitHostPort(bindAddress)
+	if err != nil {
+		return NodeConfig{}, fmt.Errorf("bad bind address: %w", err)
+	}
+	if externalAddress == "" {
+		// Listening on every interface doesn't say which one other nodes should use
+		if host == "" || net.ParseIP(host) != nil && net.ParseIP(host).IsUnspecified() {
+			host = "localhost"
+		}
+		externalAddress = net.JoinHostPort(host, port)
+	}
+	if _, _, err := net.SplitHostPort(externalAddress); err != nil {
+		return NodeConfig{}, fmt.Errorf("bad external address: %w", err)
+	}
+	if len(seeds) == 0 {
+		seeds = []string{defaultSeedNode}
+	}
+	for _, seed := range seeds {
+		if _, _, err := net.SplitHostPort(seed); err != nil {
+			return NodeConfig{}, fmt.Errorf("bad seed address: %w", err)
+		}
+	}
+	return NodeConfig{bindAddress, externalAddress, seeds}, nil
+}
+
+func StartServer(nodeID, minerAddress string, config NodeConfig) {
+	nodeAddress = config.ExternalAddress
 	miningAddress = minerAddress
-	listener, err := net.Listen(protocol, nodeAddress)
+	listener, err := net.Listen(protocol, config.BindAddress)
 	if err != nil {
 		log.Panic(err)
 	}
-	defer listener.Close()
 
 	bc := NewBlockchain(nodeID)
+	defer bc.db.Close()
 
-	// All nodes (excluding the central one) send a version to the central
-	if nodeAddress != knownNodes[0] {
-		sendVersion(knownNodes[0], bc)
+	addrBook, err = LoadAddressBook(fmt.Sprintf(addrBookFile, nodeID))
+	if err != nil {
+		log.Panic(err)
+	}
+	for _, seed := range config.Seeds {
+		addrBook.Add(seed, 0, time.Time{})
+	}
+	bans, err = LoadBanList(fmt.Sprintf(banListFile, nodeID))
+	if err != nil {
+		log.Panic(err)
 	}
 
+	// Stop cleanly on Ctrl-C, letting peers receive what was already queued for them
+	stopping := make(chan os.Signal, 1)
+	signal.Notify(stopping, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-stopping
+		fmt.Println("Shutting down...")
+		listener.Close()
+	}()
+
+	quit := make(chan struct{})
+	var background sync.WaitGroup
+	background.Add(3)
+	go func() {
+		defer background.Done()
+		manageConnections(bc, quit)
+	}()
+	go func() {
+		defer background.Done()
+		watchDownloads(quit)
+	}()
+	go func() {
+		defer background.Done()
+		watchPeers(fmt.Sprintf(peerInfoFile, nodeID), quit)
+	}()
+
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				close(quit)
+				background.Wait()
+				disconnectAll(shutdownTimeout)
+				if err := addrBook.Save(); err != nil {
+					fmt.Printf("Unable to save the address book: %s\n", err)
+				}
+				return
+			}
 			log.Panic(err)
 		}
-		go handleConnection(conn, bc)
+		peer := newPeer(conn, "", true)
+		if bans.IsBanned(peer.Host()) {
+			fmt.Printf("Refusing a connection from %s, it is banned\n", peer.Host())
+			conn.Close()
+			continue
+		}
+		if err := checkInbound(peer.Host()); err != nil {
+			fmt.Printf("Refusing a connection from %s: %s\n", peer.Host(), err)
+			conn.Close()
+			continue
+		}
+		addPeer(peer)
+		peer.start(messageHandler(bc))
 	}
 }
 
-func handleConnection(conn net.Conn, bc *Blockchain) {
-	req, err := io.ReadAll(conn)
+// connectToPeer returns the connected peer listening on addr, connecting to it and starting the
+// handshake if need be. Nodes that can't be reached are tried again later by the connection manager.
+func connectToPeer(addr string, bc *Blockchain) *Peer {
+	if peer := findPeer(addr); peer != nil {
+		return peer
+	}
+	addrBook.Attempt(addr)
+	peer, err := dialPeer(addr, messageHandler(bc))
 	if err != nil {
-		log.Panic(err)
+		fmt.Printf("%s is not available\n", addr)
+		return nil
+	}
+	if bans.IsBanned(peer.Host()) {
+		fmt.Printf("Disconnecting %s, its host is banned\n", peer)
+		peer.Disconnect()
+		return nil
 	}
-	command := bytesToCommand(req[:commandLength])
-	fmt.Printf("Received [%s] command\n", command)
 
+	handlerMutex.Lock()
+	defer handlerMutex.Unlock()
+	sendVersion(peer, bc)
+	return peer
+}
+
+// messageHandler returns the function a peer's read loop hands each message to
+func messageHandler(bc *Blockchain) func(peer *Peer, command string, payload []byte) {
+	return func(peer *Peer, command string, payload []byte) {
+		handleMessage(peer, command, payload, bc)
+	}
+}
+
+func handleMessage(peer *Peer, command string, payload []byte, bc *Blockchain) {
+	handlerMutex.Lock()
+	defer handlerMutex.Unlock()
+	fmt.Printf("Received [%s] command from %s\n", command, peer)
+	if command != "version" && command != "verack" && !peer.HandshakeDone() {
+		fmt.Printf("Ignoring [%s] from %s before the handshake\n", command, peer)
+		return
+	}
+
+	var err error
 	switch command {
 	case "inventory":
-		handleInventory(req, bc)
+		err = handleInventory(peer, payload, bc)
 	case "version":
-		handleVersion(req, bc)
+		err = handleVersion(peer, payload, bc)
+	case "verack":
+		handleVerack(peer, bc)
 	case "getblocks":
-		handleGetBlocks(req, bc)
+		err = handleGetBlocks(peer, payload, bc)
+	case "getheaders":
+		err = handleGetHeaders(peer, payload, bc)
+	case "headers":
+		err = handleHeaders(peer, payload, bc)
 	case "blockdata":
-		handleBlockData(req, bc)
+		err = handleBlockData(peer, payload, bc)
 	case "getdata":
-		handleGetData(req, bc)
+		err = handleGetData(peer, payload, bc)
 	case "txdata":
-		handleTxData(req, bc)
+		err = handleTxData(peer, payload, bc)
+	case "mempool":
+		handleMempool(peer)
+	case "getaddr":
+		handleGetAddr(peer)
+	case "addr":
+		err = handleAddr(peer, payload)
+	case "ping":
+		err = handlePing(peer, payload)
+	case "pong":
+		err = handlePong(peer, payload)
 	default:
 		fmt.Println("Unknown Command!")
 	}
-
-	conn.Close()
+	if err != nil {
+		handlerFailed(peer, command, err)
+	}
 }
 
-func handleInventory(req []byte, bc *Blockchain) {
-	var buffer bytes.Buffer
+func handleInventory(peer *Peer, payload []byte, bc *Blockchain) error {
 	var inv Inventory
-
-	buffer.Write(req[commandLength:])
-	decoder := gob.NewDecoder(&buffer)
-	err := decoder.Decode(&inv)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(payload, &inv); err != nil {
+		return err
+	}
+	if err := checkHashes(inv.Items, maxInventoryItems); err != nil {
+		return err
 	}
 
 	fmt.Printf("Received inventory with %d %s\n", len(inv.Items), inv.Type)
 	if inv.Type == "block" {
-		// Record the block hashes from the incoming message and mark them for later download
-		blocksInTransit = inv.Items
-
-		// Immediately download the first block (in reality, blocks would be downloaded from different nodes)
-		blockHash := inv.Items[0]
-		sendGetData(inv.AddrFrom, "block", blockHash)
-
-		newInTransit := [][]byte{}
-		for _, block := range blocksInTransit {
-			if bytes.Compare(block, blockHash) != 0 {
-				newInTransit = append(newInTransit, block)
+		for _, hash := range inv.Items {
+			peer.knownInventory.Add(hash)
+		}
+		// Fetch the headers leading to the newest block we haven't heard of, then the blocks are downloaded
+		for i := len(inv.Items) - 1; i >= 0; i-- {
+			if !downloads.isKnown(inv.Items[i], bc) && peer.Supports(headersVersion) {
+				sendGetHeaders(peer, downloads.locator(bc), inv.Items[i])
+				break
 			}
 		}
-		blocksInTransit = newInTransit
 	}
 
 	if inv.Type == "tx" {
-		//do tx inventory
-	}
-}
-
-func handleVersion(req []byte, bc *Blockchain) {
-	var buffer bytes.Buffer
-	var version Version
-
-	buffer.Write(req[commandLength:])
-	decoder := gob.NewDecoder(&buffer)
-	err := decoder.Decode(&version)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	myBestHeight := bc.GetBestHeight()
-	otherBestHeight := version.BestHeight
-
-	if myBestHeight < otherBestHeight {
-		sendGetBlocks(version.AddrFrom)
-	} else if myBestHeight > otherBestHeight {
-		//send version back
-		sendVersion(version.AddrFrom, bc)
-	}
-
-	if !nodeIsKnown(version.AddrFrom) {
-		knownNodes = append(knownNodes, version.AddrFrom)
+		requestTransactions(peer, inv.Items)
 	}
+	return nil
 }
 
-func handleGetBlocks(req []byte, bc *Blockchain) {
-	var buffer bytes.Buffer
+func handleGetBlocks(peer *Peer, payload []byte, bc *Blockchain) error {
 	var getblocks GetBlocks
-
-	buffer.Write(req[commandLength:])
-	decoder := gob.NewDecoder(&buffer)
-	err := decoder.Decode(&getblocks)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(payload, &getblocks); err != nil {
+		return err
+	}
+	if err := checkHashes(getblocks.Locator, maxLocatorLen); err != nil {
+		return err
 	}
 
-	blocks := bc.GetBlockHashes()
-	sendInventory(getblocks.AddrFrom, "block", blocks)
+	blocks := bc.LocateBlocks(getblocks.Locator, getblocks.StopHash, maxBlocksPerInventory)
+	sendInventory(peer, "block", blocks)
+	return nil
 }
 
-func handleGetData(req []byte, bc *Blockchain) {
-	var buffer bytes.Buffer
+func handleGetData(peer *Peer, payload []byte, bc *Blockchain) error {
 	var getdata GetData
-
-	buffer.Write(req[commandLength:])
-	decoder := gob.NewDecoder(&buffer)
-	err := decoder.Decode(&getdata)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(payload, &getdata); err != nil {
+		return err
+	}
+	if err := checkHashes([][]byte{getdata.ID}, 1); err != nil {
+		return err
 	}
 
 	if getdata.Type == "block" {
+		if !bc.HasBlockData(getdata.ID) {
+			fmt.Printf("Refusing request for block %x, it has been pruned or is unknown\n", getdata.ID)
+			return nil
+		}
 		block, err := bc.GetBlock([]byte(getdata.ID))
 		if err != nil {
 			log.Panic(err)
 		}
-		sendBlock(getdata.AddrFrom, &block)
+		sendBlock(peer, &block)
 	}
 
 	if getdata.Type == "tx" {
-		txID := hex.EncodeToString(getdata.ID)
-		tx := mempool[txID]
-		sendTx(getdata.AddrFrom, &tx)
+		tx, found := mempool[hex.EncodeToString(getdata.ID)]
+		if !found {
+			fmt.Printf("Refusing request for transaction %x, it isn't in the mempool\n", getdata.ID)
+			return nil
+		}
+		sendTx(peer, &tx)
 	}
+	return nil
 }
 
-func handleBlockData(request []byte, bc *Blockchain) {
-	var buffer bytes.Buffer
+func handleBlockData(peer *Peer, payload []byte, bc *Blockchain) error {
 	var blockdata BlockData
-
-	buffer.Write(request[commandLength:])
-	decoder := gob.NewDecoder(&buffer)
-	err := decoder.Decode(&blockdata)
-	if err != nil {
-		log.Panic(err)
+	if err := decodePayload(payload, &blockdata); err != nil {
+		return err
 	}
-
-	fmt.Println("Received a new block!")
-	block := DeserializeBlock(blockdata.Block)
-	bc.AddBlock(block)
-
-	fmt.Printf("Added block %x\n\n", block.Hash)
-	// If there are more blocks to download, then request them now (from the node that just sent us this one)
-	if len(blocksInTransit) > 0 {
-		blockHash := blocksInTransit[0]
-		sendGetData(blockdata.AddrFrom, "block", blockHash)
-		blocksInTransit = blocksInTransit[1:]
-	} else {
-		// If we have all the blocks, reindex the utxo set
-		utxoSet := UTXOSet{bc}
-		utxoSet.Reindex()
+	var block Block
+	if err := decodePayload(blockdata.Block, &block); err != nil {
+		return err
 	}
+
+	downloads.blockReceived(peer, &block, bc)
+	return nil
 }
 
-func handleTxData(request []byte, bc *Blockchain) {
-	var buffer bytes.Buffer
-	var txdata TxData
-
-	buffer.Write(request[commandLength:])
-	decoder := gob.NewDecoder(&buffer)
-	decoder.Decode(&txdata)
-
-	// First things first, add the tx to the mempool
-	txbytes := txdata.Transaction
-	tx := DeserializeTransaction(txbytes)
-	mempool[hex.EncodeToString(tx.ID)] = tx
-
-	// If this node is the central node, just propagate the transactions
-	if nodeAddress == knownNodes[0] {
-		for _, node := range knownNodes {
-			if node != nodeAddress && node != txdata.AddrFrom {
-				sendInventory(node, "tx", [][]byte{tx.ID})
-			}
+// mineTransactions mines blocks of the valid transactions in the mempool until it is empty
+func mineTransactions(bc *Blockchain) {
+MineTransactions:
+	var txs []*Transaction
+	// verify all the transactions. Those that can never be mined, as what they spend has been spent
+	// or disconnected from the chain since they were accepted, are dropped from the mempool, and
+	// those spending the same outputs as a transaction earlier in this block are left out.
+	utxoSet := UTXOSet{bc}
+	spent := make(map[string]bool)
+	for txID, tx := range mempool {
+		tx := tx
+		if !spendsUnspentOutputs(utxoSet, &tx, nil) {
+			fmt.Printf("Dropping transaction %s from the mempool, it spends outputs that aren't unspent\n", txID)
+			delete(mempool, txID)
+			continue
 		}
-	}
-	// If this is the miner node, mine transactions
-	if len(miningAddress) > 0 && len(mempool) > 2 {
-	MineTransactions:
-		var txs []*Transaction
-		// verify all the transactions
-		for _, tx := range mempool {
-			if bc.VerifyTransaction(&tx) {
-				txs = append(txs, &tx)
-			}
+		if err := bc.checkTransaction(&tx, nil); err != nil {
+			fmt.Printf("Dropping transaction %s from the mempool: %s\n", txID, err)
+			delete(mempool, txID)
+			continue
 		}
-
-		if len(txs) == 0 {
-			fmt.Println("All transactions are invalid! Waiting for new ones...")
-			return
+		if !spendsUnspentOutputs(utxoSet, &tx, spent) {
+			continue
+		}
+		for _, input := range tx.Inputs {
+			spent[hex.EncodeToString(outpointKey(input.TxOutputID, input.TxOutputIndex))] = true
 		}
+		txs = append(txs, &tx)
+	}
 
-		// Create coinbase txn and add to block
-		coinbaseTx := NewCoinbaseTx(miningAddress, "")
-		txs = append(txs, coinbaseTx)
-		newBlock := bc.MineBlock(txs)
+	if len(txs) == 0 {
+		fmt.Println("All transactions are invalid! Waiting for new ones...")
+		return
+	}
 
-		// Re-index now that we've added the new block
-		utxoSet := UTXOSet{bc}
-		utxoSet.Reindex()
+	// Create coinbase txn and add to block
+	coinbaseTx := NewCoinbaseTx(miningAddress, "")
+	txs = append(txs, coinbaseTx)
+	newBlock := bc.MineBlock(txs)
 
-		fmt.Println("New block has been mined!")
+	pruneBlocks(bc)
 
-		// Remove mined txs from mempool
-		for _, tx := range txs {
-			txID := hex.EncodeToString(tx.ID)
-			delete(mempool, txID)
-		}
+	fmt.Println("New block has been mined!")
 
-		// Inform the other nodes that a new block exists
-		for _, node := range knownNodes {
-			sendInventory(node, "block", [][]byte{newBlock.Hash})
-		}
+	// Remove mined txs from mempool
+	removeFromMempool(newBlock)
 
-		// Repeat until the mempool is clear
-		if len(mempool) > 0 {
-			goto MineTransactions
+	// Inform the other nodes that a new block exists
+	for _, other := range activePeers() {
+		if !other.knownInventory.Has(newBlock.Hash) {
+			other.knownInventory.Add(newBlock.Hash)
+			sendInventory(other, "block", [][]byte{newBlock.Hash})
 		}
 	}
 
-}
-func sendTx(addr string, tx *Transaction) {
-	data := TxData{nodeAddress, tx.Serialize()}
-	payload := gobEncode(data)
-	request := append(commandToBytes("txdata"), payload...)
-	sendData(addr, request)
-}
-
-func sendBlock(addr string, b *Block) {
-	data := BlockData{nodeAddress, b.Serialize()}
-	payload := gobEncode(data)
-	request := append(commandToBytes("blockdata"), payload...)
-	sendData(addr, request)
-}
-
-func sendInventory(addr string, tipe string, items [][]byte) {
-	payload := gobEncode(Inventory{nodeAddress, tipe, items})
-	request := append(commandToBytes("inventory"), payload...)
-	sendData(addr, request)
+	// Repeat until the mempool is clear
+	if len(mempool) > 0 {
+		goto MineTransactions
+	}
 }
 
-func sendGetBlocks(addr string) {
-	payload := gobEncode(GetBlocks{nodeAddress})
-	request := append(commandToBytes("getblocks"), payload...)
-	sendData(addr, request)
+// spendsUnspentOutputs reports whether every output tx spends is in the UTXO set and not in spent
+func spendsUnspentOutputs(utxoSet UTXOSet, tx *Transaction, spent map[string]bool) bool {
+	for _, input := range tx.Inputs {
+		key := hex.EncodeToString(outpointKey(input.TxOutputID, input.TxOutputIndex))
+		if _, found := utxoSet.GetUtxo(input.TxOutputID, input.TxOutputIndex); !found || spent[key] {
+			return false
+		}
+	}
+	return true
 }
 
-func sendGetData(addr string, tipe string, id []byte) {
-	payload := gobEncode(GetData{nodeAddress, tipe, id})
-	request := append(commandToBytes("getdata"), payload...)
-	sendData(addr, request)
+// pruneBlocks deletes old block bodies if the chain is in pruning mode
+func pruneBlocks(bc *Blockchain) {
+	pruned, err := bc.Prune()
+	if err != nil {
+		log.Panic(err)
+	}
+	if pruned > 0 {
+		fmt.Printf("Pruned %d old blocks\n", pruned)
+	}
 }
 
-func sendVersion(addr string, bc *Blockchain) {
-	bestHeight := bc.GetBestHeight()
-	payload := gobEncode(Version{nodeVersion, bestHeight, nodeAddress})
-	request := append(commandToBytes("version"), payload...)
-	sendData(addr, request)
+func sendTx(peer *Peer, tx *Transaction) {
+	data := TxData{nodeAddress, tx.Serialize()}
+	peer.Send("txdata", gobEncode(data))
 }
 
-func sendData(addr string, data []byte) {
-	conn, err := net.Dial(protocol, addr)
+// sendTxTo hands a transaction to the node at addr over a short lived connection, for wallets
+// that aren't running a node. The wallet shakes hands as a peer offering no services.
+func sendTxTo(addr string, tx *Transaction) {
+	conn, err := net.DialTimeout(protocol, addr, peerDialTimeout)
 	if err != nil {
 		fmt.Printf("%s is not available\n", addr)
-		var updatedNodes []string
-		for _, node := range knownNodes {
-			if node != addr {
-				updatedNodes = append(updatedNodes, node)
-			}
-		}
-		knownNodes = updatedNodes
 		return
 	}
 	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(peerDialTimeout))
 
-	_, err = io.Copy(conn, bytes.NewReader(data))
-	if err != nil {
+	version := Version{
+		Version:   protocolVersion,
+		UserAgent: userAgent,
+		Nonce:     localNonce,
+		Timestamp: time.Now().Unix(),
+	}
+	if err := writeMessage(conn, "version", gobEncode(version)); err != nil {
 		log.Panic(err)
 	}
-}
-
-func nodeIsKnown(addr string) bool {
-	for _, node := range knownNodes {
-		if addr == node {
-			return true
+	reader := bufio.NewReader(conn)
+	for {
+		command, _, err := readMessage(reader)
+		if err != nil {
+			fmt.Printf("%s didn't complete the handshake: %s\n", addr, err)
+			return
+		}
+		if command == "verack" {
+			break
 		}
 	}
-	return false
+
+	data := TxData{nodeAddress, tx.Serialize()}
+	if err := writeMessage(conn, "verack", nil); err != nil {
+		log.Panic(err)
+	}
+	if err := writeMessage(conn, "txdata", gobEncode(data)); err != nil {
+		log.Panic(err)
+	}
+}
+
+func sendBlock(peer *Peer, b *Block) {
+	data := BlockData{nodeAddress, b.Serialize()}
+	peer.Send("blockdata", gobEncode(data))
+}
+
+func sendInventory(peer *Peer, tipe string, items [][]byte) {
+	peer.Send("inventory", gobEncode(Inventory{nodeAddress, tipe, items}))
+}
+
+func sendGetData(peer *Peer, tipe string, id []byte) {
+	peer.Send("getdata", gobEncode(GetData{nodeAddress, tipe, id}))
 }
 
 func bytesToCommand(data []byte) string {
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNodeConfig(t *testing.T) {
+	config, err := NewNodeConfig("3001", "", "", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, NodeConfig{"localhost:3001", "localhost:3001", []string{defaultSeedNode}}, config)
+
+	config, err = NewNodeConfig("3001", "0.0.0.0:4000", "", []string{"10.0.0.1:3000", "10.0.0.2:3000"})
+	assert.Nil(t, err)
+	assert.Equal(t, "localhost:4000", config.ExternalAddress, "Listening on every interface doesn't say which to advertise")
+	assert.Len(t, config.Seeds, 2)
+
+	config, err = NewNodeConfig("3001", ":4000", "node1.example.com:4000", nil)
+	assert.Nil(t, err)
+	assert.Equal(t, "node1.example.com:4000", config.ExternalAddress)
+
+	_, err = NewNodeConfig("3001", "localhost", "", nil)
+	assert.ErrorContains(t, err, "bad bind address")
+	_, err = NewNodeConfig("3001", "", "", []string{"nowhere"})
+	assert.ErrorContains(t, err, "bad seed address")
+}
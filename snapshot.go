@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+)
+
+// A UTXO snapshot is the chainstate as it was at one block, along with what a node needs to carry
+// on syncing from there without the earlier blocks: every header up to that block and the block
+// itself. A node bootstrapped from a snapshot behaves like a pruned node whose blocks below the
+// snapshot height have been deleted.
+//
+// On disk a snapshot is the magic bytes, a 4 byte big endian format version, then the gob encoded
+// UtxoSnapshot. The snapshot hash covers the height, block hash and every unspent output. The
+// headers and block aren't hashed, but are checked to hash back to the block hash, so a trusted
+// snapshot hash vouches for them too.
+const snapshotMagic = "gcus"
+const snapshotVersion = 2
+
+type SnapshotUtxo struct {
+	Outpoint []byte
+	Entry    UtxoEntry
+}
+
+type UtxoSnapshot struct {
+	Height    int
+	BlockHash []byte
+	Headers   []BlockHeader  // genesis up to and including the snapshot block
+	Block     []byte         // the serialized snapshot block
+	Utxos     []SnapshotUtxo // in outpoint order
+	Hash      []byte
+}
+
+// ComputeHash hashes the snapshot contents in a fixed encoding (so it doesn't depend on gob)
+func (snapshot *UtxoSnapshot) ComputeHash() []byte {
+	hasher := sha256.New()
+	hasher.Write(Int64ToBytes(int64(snapshot.Height)))
+	hasher.Write(snapshot.BlockHash)
+	for _, utxo := range snapshot.Utxos {
+		hasher.Write(utxo.Outpoint)
+		hasher.Write(Int64ToBytes(int64(utxo.Entry.Value)))
+		hasher.Write(Int64ToBytes(int64(utxo.Entry.Height)))
+		if utxo.Entry.Coinbase {
+			hasher.Write([]byte{1})
+		} else {
+			hasher.Write([]byte{0})
+		}
+		hasher.Write(Int64ToBytes(int64(len(utxo.Entry.PubKeyHash))))
+		hasher.Write(utxo.Entry.PubKeyHash)
+	}
+	return hasher.Sum(nil)
+}
+
+// validate checks the snapshot is internally consistent: the hash matches the contents, the
+// headers are a chain with valid proof of work from genesis to the snapshot block, and the block
+// is the one the last header describes
+func (snapshot *UtxoSnapshot) validate() error {
+	if !bytes.Equal(snapshot.Hash, snapshot.ComputeHash()) {
+		return errors.New("snapshot is corrupt, its contents don't match its hash")
+	}
+	if len(snapshot.Headers) != snapshot.Height+1 {
+		return errors.New("snapshot doesn't have a header for every block")
+	}
+
+	genesis := &snapshot.Headers[0]
+	if genesis.Height != 0 || len(genesis.PrevBlockHash) != 0 {
+		return errors.New("snapshot headers don't start at a genesis block")
+	}
+	if err := checkHeaderProofOfWork(genesis); err != nil {
+		return fmt.Errorf("snapshot headers are invalid: %s", err)
+	}
+	for height := 1; height < len(snapshot.Headers); height++ {
+		if err := CheckHeader(&snapshot.Headers[height], &snapshot.Headers[height-1]); err != nil {
+			return fmt.Errorf("snapshot headers are invalid: %s", err)
+		}
+	}
+	last := snapshot.Headers[snapshot.Height]
+	if !bytes.Equal(last.Hash, snapshot.BlockHash) {
+		return errors.New("snapshot headers don't lead to the snapshot block")
+	}
+
+	block, err := decodeBlock(snapshot.Block)
+	if err != nil {
+		return fmt.Errorf("snapshot block is malformed: %s", err)
+	}
+	if !bytes.Equal(block.Hash, snapshot.BlockHash) || block.Height != snapshot.Height {
+		return errors.New("snapshot block doesn't match the snapshot block hash")
+	}
+	if err := CheckProofOfWork(block); err != nil {
+		return fmt.Errorf("snapshot block is invalid: %s", err)
+	}
+	if !bytes.Equal(block.HashTransactions(), last.MerkleRoot) {
+		return errors.New("snapshot block transactions don't match its header")
+	}
+	return nil
+}
+
+// CheckTrusted compares the snapshot hash with the one in the chain params for its height or,
+// if the chain params don't have one, with expectedHash (hex)
+func (snapshot *UtxoSnapshot) CheckTrusted(expectedHash string) error {
+	trusted, ok := chainParams.AssumeUtxo[snapshot.Height]
+	if !ok {
+		trusted = expectedHash
+	}
+	if trusted == "" {
+		return fmt.Errorf("no trusted hash for a snapshot at height %d", snapshot.Height)
+	}
+	if hex.EncodeToString(snapshot.Hash) != trusted {
+		return fmt.Errorf("snapshot hash %x doesn't match the trusted hash %s", snapshot.Hash, trusted)
+	}
+	return nil
+}
+
+// CreateUtxoSnapshot snapshots the chainstate at the main chain block at height. Snapshots below
+// the tip are rebuilt from the blocks, so on a pruned chain only the tip can be snapshotted.
+func (blockchain *Blockchain) CreateUtxoSnapshot(height int) (*UtxoSnapshot, error) {
+	snapshot := &UtxoSnapshot{Height: height}
+	var atTip bool
+	err := blockchain.db.View(func(tx ChainTx) error {
+		for _, hash := range tx.GetHashesInRange(0, height) {
+			snapshot.Headers = append(snapshot.Headers, *tx.GetHeader(hash))
+			snapshot.BlockHash = hash
+		}
+		if len(snapshot.Headers) != height+1 {
+			return fmt.Errorf("there is no main chain block at height %d", height)
+		}
+
+		block := tx.GetBlock(snapshot.BlockHash)
+		if block == nil {
+			return fmt.Errorf("block at height %d has been pruned", height)
+		}
+		snapshot.Block = block.Serialize()
+
+		atTip = bytes.Equal(tx.GetTip(), snapshot.BlockHash)
+		if !atTip {
+			if tx.GetPruneState().PrunedHeight > 0 {
+				return errors.New("only the tip of a pruned chain can be snapshotted")
+			}
+			return nil
+		}
+		return tx.ForEachUtxo(func(outpoint []byte, entry UtxoEntry) error {
+			snapshot.Utxos = append(snapshot.Utxos, SnapshotUtxo{outpoint, entry})
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !atTip {
+		utxoMap := blockchain.buildUtxoMapFrom(snapshot.BlockHash)
+		outpoints := make([]string, 0, len(utxoMap))
+		for outpoint := range utxoMap {
+			outpoints = append(outpoints, outpoint)
+		}
+		sort.Strings(outpoints) // hex sorts the same as the raw bytes
+		for _, outpoint := range outpoints {
+			key, _ := hex.DecodeString(outpoint)
+			snapshot.Utxos = append(snapshot.Utxos, SnapshotUtxo{key, utxoMap[outpoint]})
+		}
+	}
+
+	snapshot.Hash = snapshot.ComputeHash()
+	return snapshot, nil
+}
+
+func WriteUtxoSnapshot(w io.Writer, snapshot *UtxoSnapshot) error {
+	header := make([]byte, len(snapshotMagic)+4)
+	copy(header, snapshotMagic)
+	binary.BigEndian.PutUint32(header[len(snapshotMagic):], snapshotVersion)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	return gob.NewEncoder(w).Encode(snapshot)
+}
+
+// ReadUtxoSnapshot reads a snapshot and checks it is intact. It doesn't check that the snapshot
+// can be trusted, see CheckTrusted.
+func ReadUtxoSnapshot(r io.Reader) (*UtxoSnapshot, error) {
+	header := make([]byte, len(snapshotMagic)+4)
+	if _, err := io.ReadFull(r, header); err != nil || string(header[:len(snapshotMagic)]) != snapshotMagic {
+		return nil, errors.New("not a UTXO snapshot")
+	}
+	if version := binary.BigEndian.Uint32(header[len(snapshotMagic):]); version != snapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, this node reads version %d", version, snapshotVersion)
+	}
+
+	var snapshot UtxoSnapshot
+	if err := gob.NewDecoder(r).Decode(&snapshot); err != nil {
+		return nil, err
+	}
+	if err := snapshot.validate(); err != nil {
+		return nil, err
+	}
+	return &snapshot, nil
+}
+
+// LoadBlockchainFromSnapshot creates a chain in the empty store db from a snapshot. The chain
+// starts at the snapshot block, with no blocks (or address history) from before it.
+func LoadBlockchainFromSnapshot(db ChainStore, snapshot *UtxoSnapshot, withTxIndex bool) *Blockchain {
+	err := db.Update(func(tx ChainTx) error {
+		if tx.GetTip() != nil {
+			return errors.New("store already holds a chain")
+		}
+
+		if err := tx.PutSchemaVersion(currentSchemaVersion()); err != nil {
+			return err
+		}
+
+		for _, header := range snapshot.Headers {
+			header := header
+			if err := tx.PutHeader(&header); err != nil {
+				return err
+			}
+			if err := tx.PutHashAtHeight(header.Height, header.Hash); err != nil {
+				return err
+			}
+		}
+
+		block := DeserializeBlock(snapshot.Block)
+		if err := tx.PutBlock(block); err != nil {
+			return err
+		}
+		if err := tx.PutTip(block.Hash); err != nil {
+			return err
+		}
+		if withTxIndex {
+			if err := tx.CreateTxIndex(); err != nil {
+				return err
+			}
+			if err := indexBlockTxs(tx, block); err != nil {
+				return err
+			}
+		}
+		for _, transaction := range block.Transactions {
+			if err := indexTxHistory(tx, transaction, block.Height); err != nil {
+				return err
+			}
+		}
+
+		for _, utxo := range snapshot.Utxos {
+			if err := tx.PutUtxo(utxo.Outpoint, utxo.Entry); err != nil {
+				return err
+			}
+		}
+
+		// Blocks below the snapshot were never downloaded, which is the same as having pruned them
+		return tx.PutPruneState(PruneState{PrunedHeight: snapshot.Height})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return NewBlockchainFromStore(db)
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestUtxoSnapshotRoundTrip(t *testing.T) {
+	_, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := CreateBlockchainInStore(alice, NewMemoryStore(), true)
+	utxoSet := UTXOSet{bc}
+	utxoSet.Reindex()
+	bc.MineBlock([]*Transaction{NewCoinbaseTx(bob, "block 1")})
+
+	// A snapshot below the tip is rebuilt from the blocks
+	snapshot, err := bc.CreateUtxoSnapshot(0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(snapshot.Utxos))
+
+	snapshot, err = bc.CreateUtxoSnapshot(1)
+	assert.Nil(t, err)
+	var buffer bytes.Buffer
+	assert.Nil(t, WriteUtxoSnapshot(&buffer, snapshot))
+	data := buffer.Bytes()
+
+	loaded, err := ReadUtxoSnapshot(bytes.NewReader(data))
+	assert.Nil(t, err)
+	assert.NotNil(t, loaded.CheckTrusted(""), "An unknown snapshot isn't trusted")
+	assert.Nil(t, loaded.CheckTrusted(hex.EncodeToString(snapshot.Hash)))
+
+	restored := LoadBlockchainFromSnapshot(NewMemoryStore(), loaded, true)
+	assert.Equal(t, 1, restored.GetBestHeight())
+	assert.True(t, restored.IsPruned(), "The genesis block was never downloaded")
+	assert.Equal(t, 10, balanceOf(UTXOSet{restored}, bob))
+	again, err := restored.CreateUtxoSnapshot(1)
+	assert.Nil(t, err)
+	assert.Equal(t, snapshot.Hash, again.Hash)
+
+	data[len(data)-40] ^= 0xff
+	_, err = ReadUtxoSnapshot(bytes.NewReader(data))
+	assert.NotNil(t, err, "A damaged snapshot is rejected")
+	data[len(snapshotMagic)+3] = snapshotVersion + 1
+	_, err = ReadUtxoSnapshot(bytes.NewReader(data))
+	assert.ErrorContains(t, err, "unsupported snapshot version")
+}
+
+func TestUtxoSnapshotRejectsForgedHeadersAndBlock(t *testing.T) {
+	_, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := CreateBlockchainInStore(alice, NewMemoryStore(), false)
+	UTXOSet{bc}.Reindex()
+	block := bc.MineBlock([]*Transaction{NewCoinbaseTx(bob, "block 1")})
+	snapshot, err := bc.CreateUtxoSnapshot(1)
+	assert.Nil(t, err)
+	assert.Nil(t, snapshot.validate())
+
+	// Neither the headers nor the block are covered by the snapshot hash, so they must hash back
+	// to the block hash
+	forged := *snapshot
+	forged.Headers = append([]BlockHeader{}, snapshot.Headers...)
+	forged.Headers[0].Timestamp++
+	assert.ErrorContains(t, forged.validate(), "headers are invalid")
+
+	forgedBlock := *block
+	forgedBlock.Transactions = []*Transaction{NewCoinbaseTx(alice, "forged")}
+	forged = *snapshot
+	forged.Block = forgedBlock.Serialize()
+	assert.ErrorContains(t, forged.validate(), "snapshot block is invalid")
+
+	forged.Block = []byte("not a block")
+	assert.ErrorContains(t, forged.validate(), "malformed")
+}
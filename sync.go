@@ -0,0 +1,345 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/exp/slices"
+	"time"
+)
+
+// Blocks are synced headers first. A node asks a peer that is ahead of it for headers (getheaders,
+// carrying a locator of where the node is), checks they form a valid chain and only then downloads
+// the blocks of that chain, from every peer that has them at once. Blocks arrive in any order and
+// are connected in height order as their parents are. A peer that doesn't deliver a block in time
+// is dropped, and the block asked for from another peer.
+
+var errUnconnectedHeaders = errors.New("headers don't follow a block we know")
+
+// maxHeadersPerMessage caps the headers sent in one headers message. A full message means the
+// peer probably has more, so the next batch is asked for straight away.
+const maxHeadersPerMessage = 2000
+
+// maxBlocksInFlightPerPeer is how many blocks may be asked for from one peer at a time
+const maxBlocksInFlightPerPeer = 16
+
+// blockDownloadWindow limits how far past the next block to connect blocks are downloaded, which
+// bounds the blocks held in memory waiting for their parents
+const blockDownloadWindow = 256
+
+// blockDownloadTimeout is how long a peer has to deliver a block it was asked for
+const blockDownloadTimeout = time.Minute
+
+// downloadCheckInterval is how often requests are checked for timeouts
+const downloadCheckInterval = 5 * time.Second
+
+type blockRequest struct {
+	peer      *Peer
+	requested time.Time
+}
+
+type receivedBlock struct {
+	block *Block
+	peer  *Peer
+}
+
+// blockSync is the state of the block download. Like the rest of the handlers' state it is
+// guarded by handlerMutex.
+type blockSync struct {
+	headers  map[string]*BlockHeader // checked headers of blocks we don't have yet
+	best     *BlockHeader            // the tip of the best header chain, while it is ahead of our chain
+	queue    [][]byte                // the blocks of the best header chain still to connect, oldest first
+	inFlight map[string]blockRequest
+	received map[string]receivedBlock // downloaded blocks waiting for the blocks before them
+	invalid  map[string]bool          // blocks that failed validation, and so any chain containing them
+}
+
+func newBlockSync() *blockSync {
+	return &blockSync{
+		headers:  make(map[string]*BlockHeader),
+		inFlight: make(map[string]blockRequest),
+		received: make(map[string]receivedBlock),
+		invalid:  make(map[string]bool),
+	}
+}
+
+var downloads = newBlockSync()
+
+// bestHeight is the height of the best chain we know of, downloaded or not
+func (s *blockSync) bestHeight(bc *Blockchain) int {
+	if s.best != nil {
+		return s.best.Height
+	}
+	return bc.GetBestHeight()
+}
+
+// locator describes the best chain we know of, so headers we already have aren't sent again
+func (s *blockSync) locator(bc *Blockchain) [][]byte {
+	locator := bc.BlockLocator()
+	if s.best != nil {
+		locator = append([][]byte{s.best.Hash}, locator...)
+	}
+	return locator
+}
+
+// isKnown reports whether we have the block or its header
+func (s *blockSync) isKnown(hash []byte, bc *Blockchain) bool {
+	return s.headers[hex.EncodeToString(hash)] != nil || bc.HasBlock(hash)
+}
+
+func (s *blockSync) header(hash []byte, bc *Blockchain) (*BlockHeader, error) {
+	if header := s.headers[hex.EncodeToString(hash)]; header != nil {
+		return header, nil
+	}
+	header, err := bc.GetBlockHeader(hash)
+	return &header, err
+}
+
+// addHeaders checks headers from peer form a chain following a block we know, and starts
+// downloading the blocks if it is now the best chain
+func (s *blockSync) addHeaders(peer *Peer, headers []BlockHeader, bc *Blockchain) error {
+	if len(headers) == 0 {
+		return nil
+	}
+	if len(headers) > maxHeadersPerMessage {
+		return fmt.Errorf("%d headers is more than %d", len(headers), maxHeadersPerMessage)
+	}
+	parent, err := s.header(headers[0].PrevBlockHash, bc)
+	if err != nil {
+		return errUnconnectedHeaders
+	}
+
+	bestHeight := s.bestHeight(bc)
+	for i := range headers {
+		header := &headers[i]
+		key := hex.EncodeToString(header.Hash)
+		if s.invalid[key] || s.invalid[hex.EncodeToString(header.PrevBlockHash)] {
+			s.invalid[key] = true
+			return fmt.Errorf("header %x is for an invalid chain", header.Hash)
+		}
+		if err := CheckHeader(header, parent); err != nil {
+			return err
+		}
+		if !bc.HasBlock(header.Hash) {
+			s.headers[key] = header
+		}
+		parent = header
+	}
+
+	if parent.Height > peer.BestHeight {
+		peer.BestHeight = parent.Height
+	}
+	if parent.Height > bestHeight && s.headers[hex.EncodeToString(parent.Hash)] != nil {
+		s.best = parent
+		s.rebuildQueue()
+		fmt.Printf("Best header chain is now at height %d, %d blocks to download\n", parent.Height, len(s.queue))
+	}
+	if len(headers) == maxHeadersPerMessage && parent.Height > bestHeight {
+		sendGetHeaders(peer, s.locator(bc), nil)
+	}
+	s.schedule()
+	return nil
+}
+
+// rebuildQueue lists the blocks from the best header back to the chain we have
+func (s *blockSync) rebuildQueue() {
+	s.queue = nil
+	for header := s.best; header != nil; header = s.headers[hex.EncodeToString(header.PrevBlockHash)] {
+		s.queue = append(s.queue, header.Hash)
+	}
+	slices.Reverse(s.queue)
+
+	// Blocks of a chain we have switched away from won't be connected
+	onChain := make(map[string]bool)
+	for _, hash := range s.queue {
+		onChain[hex.EncodeToString(hash)] = true
+	}
+	for key := range s.received {
+		if !onChain[key] {
+			delete(s.received, key)
+		}
+	}
+}
+
+// schedule asks peers for the next blocks to download, spreading them over the peers that have them
+func (s *blockSync) schedule() {
+	if len(s.queue) == 0 {
+		return
+	}
+
+	load := make(map[*Peer]int)
+	for _, request := range s.inFlight {
+		load[request.peer]++
+	}
+	var sources []*Peer
+	for _, peer := range activePeers() {
+		if peer.Supports(headersVersion) && peer.HasService(serviceNetworkLimited) {
+			sources = append(sources, peer)
+		}
+	}
+
+	window := s.queue
+	if len(window) > blockDownloadWindow {
+		window = window[:blockDownloadWindow]
+	}
+	for _, hash := range window {
+		key := hex.EncodeToString(hash)
+		if _, requested := s.inFlight[key]; requested {
+			continue
+		}
+		if _, received := s.received[key]; received {
+			continue
+		}
+
+		height := s.headers[key].Height
+		var source *Peer
+		for _, peer := range sources {
+			if load[peer] >= maxBlocksInFlightPerPeer || peer.BestHeight < height {
+				continue
+			}
+			if !peer.HasService(serviceNetwork) && height < peer.PrunedHeight {
+				continue
+			}
+			if source == nil || load[peer] < load[source] {
+				source = peer
+			}
+		}
+		if source == nil {
+			continue
+		}
+		sendGetData(source, "block", hash)
+		s.inFlight[key] = blockRequest{source, time.Now()}
+		load[source]++
+	}
+}
+
+// blockReceived stores a block from peer and connects every block that is now ready
+func (s *blockSync) blockReceived(peer *Peer, block *Block, bc *Blockchain) {
+	key := hex.EncodeToString(block.Hash)
+	if _, received := s.received[key]; received || s.headers[key] == nil {
+		fmt.Printf("Ignoring block %x from %s, it wasn't asked for\n", block.Hash, peer)
+		return
+	}
+	delete(s.inFlight, key)
+	s.received[key] = receivedBlock{block, peer}
+
+	s.connectBlocks(bc)
+	s.schedule()
+}
+
+func (s *blockSync) connectBlocks(bc *Blockchain) {
+	for len(s.queue) > 0 {
+		key := hex.EncodeToString(s.queue[0])
+		received, found := s.received[key]
+		if !found {
+			return
+		}
+		s.queue = s.queue[1:]
+		delete(s.received, key)
+		delete(s.headers, key)
+
+		if _, err := bc.ImportBlock(received.block); errors.Is(err, errPrunedReorg) {
+			fmt.Printf("Unable to connect block %x: %s\n", received.block.Hash, err)
+			s.reset(bc)
+			return
+		} else if err != nil {
+			s.invalid[key] = true
+			received.peer.Misbehaving(scoreInvalid, fmt.Sprintf("block %x is invalid: %s", received.block.Hash, err))
+			s.reset(bc)
+			return
+		}
+		fmt.Printf("Added block %x at height %d\n", received.block.Hash, received.block.Height)
+		removeFromMempool(received.block)
+		pruneBlocks(bc)
+	}
+	if s.best != nil {
+		s.best = nil
+		requestMempools()
+	}
+}
+
+// reset abandons the download after an invalid block, and asks the peers that are ahead of us for
+// their headers again
+func (s *blockSync) reset(bc *Blockchain) {
+	invalid := s.invalid
+	*s = *newBlockSync()
+	s.invalid = invalid
+
+	bestHeight := bc.GetBestHeight()
+	for _, peer := range activePeers() {
+		if peer.Supports(headersVersion) && peer.BestHeight > bestHeight {
+			sendGetHeaders(peer, s.locator(bc), nil)
+		}
+	}
+}
+
+// checkTimeouts drops peers that haven't delivered blocks in time, and asks other peers for them
+func (s *blockSync) checkTimeouts() {
+	now := time.Now()
+	for key, request := range s.inFlight {
+		if request.peer.disconnecting() {
+			delete(s.inFlight, key)
+		} else if now.Sub(request.requested) > blockDownloadTimeout {
+			fmt.Printf("Disconnecting %s, it didn't send block %s within %s\n", request.peer, key, blockDownloadTimeout)
+			request.peer.Disconnect()
+			delete(s.inFlight, key)
+		}
+	}
+	s.schedule()
+}
+
+// watchDownloads checks the block download for timeouts until quit is closed
+func watchDownloads(quit chan struct{}) {
+	ticker := time.NewTicker(downloadCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			handlerMutex.Lock()
+			downloads.checkTimeouts()
+			handlerMutex.Unlock()
+		case <-quit:
+			return
+		}
+	}
+}
+
+func handleGetHeaders(peer *Peer, payload []byte, bc *Blockchain) error {
+	var getheaders GetHeaders
+	if err := decodePayload(payload, &getheaders); err != nil {
+		return err
+	}
+	if err := checkHashes(getheaders.Locator, maxLocatorLen); err != nil {
+		return err
+	}
+
+	sendHeaders(peer, bc.LocateHeaders(getheaders.Locator, getheaders.StopHash, maxHeadersPerMessage))
+	return nil
+}
+
+func handleHeaders(peer *Peer, payload []byte, bc *Blockchain) error {
+	var headers Headers
+	if err := decodePayload(payload, &headers); err != nil {
+		return err
+	}
+	if len(headers.Headers) > maxHeadersPerMessage {
+		return misbehaving(scoreMalformed, fmt.Errorf("%d headers is more than %d", len(headers.Headers), maxHeadersPerMessage))
+	}
+
+	fmt.Printf("Received %d headers\n", len(headers.Headers))
+	if err := downloads.addHeaders(peer, headers.Headers, bc); errors.Is(err, errUnconnectedHeaders) {
+		return misbehaving(scoreUnconnected, err)
+	} else if err != nil {
+		return misbehaving(scoreInvalid, err)
+	}
+	return nil
+}
+
+func sendGetHeaders(peer *Peer, locator [][]byte, stopHash []byte) {
+	peer.Send("getheaders", gobEncode(GetHeaders{nodeAddress, locator, stopHash}))
+}
+
+func sendHeaders(peer *Peer, headers []BlockHeader) {
+	peer.Send("headers", gobEncode(Headers{nodeAddress, headers}))
+}
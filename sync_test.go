@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net"
+	"testing"
+	"time"
+)
+
+// newSyncPeer returns a peer that has completed the handshake and has blocks up to bestHeight.
+// Nothing reads from it, so the messages sent to it stay in its queue.
+func newSyncPeer(bestHeight int) *Peer {
+	conn, _ := net.Pipe()
+	peer := newPeer(conn, fmt.Sprintf("peer%d", len(connectedPeers())), false)
+	peer.versionReceived, peer.verackReceived = true, true
+	peer.Version = protocolVersion
+	peer.Services = serviceNetwork | serviceNetworkLimited
+	peer.BestHeight = bestHeight
+	addPeer(peer)
+	return peer
+}
+
+func TestHeadersFirstSync(t *testing.T) {
+	_, addresses := newTestWallets(1)
+	source := CreateBlockchainInStore(addresses[0], NewMemoryStore(), false)
+	UTXOSet{source}.Reindex()
+	var blocks []*Block
+	for i := 1; i <= 3; i++ {
+		blocks = append(blocks, source.MineBlock([]*Transaction{NewCoinbaseTx(addresses[0], fmt.Sprintf("Block %d", i))}))
+	}
+	genesis, _ := source.GetBlockByHeight(0)
+	newNode := func() *Blockchain {
+		bc := CreateBlockchainFromGenesis(&genesis, NewMemoryStore(), false)
+		UTXOSet{bc}.Reindex()
+		return bc
+	}
+
+	bc := newNode()
+	assert.Equal(t, 0, source.FindForkHeight(bc.BlockLocator()), "The chains share the genesis block")
+	headers := source.LocateHeaders(bc.BlockLocator(), nil, maxHeadersPerMessage)
+	assert.Len(t, headers, 3)
+	assert.Len(t, source.LocateHeaders(bc.BlockLocator(), nil, 2), 2, "A batch is at most max headers")
+	assert.Equal(t, [][]byte{blocks[0].Hash, blocks[1].Hash}, source.LocateBlocks(bc.BlockLocator(), blocks[1].Hash, maxBlocksPerInventory),
+		"A batch ends at the stop hash")
+	assert.Equal(t, [][]byte{blocks[2].Hash}, source.LocateBlocks(source.BlockLocator()[1:], nil, maxBlocksPerInventory),
+		"Only the blocks after the fork point are sent")
+
+	peer1, peer2 := newSyncPeer(3), newSyncPeer(3)
+	defer peer1.Disconnect()
+	defer peer2.Disconnect()
+
+	s := newBlockSync()
+	assert.ErrorContains(t, s.addHeaders(peer1, headers[1:], bc), "don't follow a block we know")
+	tampered := append([]BlockHeader{}, headers...)
+	tampered[1].Nonce++
+	assert.ErrorContains(t, s.addHeaders(peer1, tampered, bc), "doesn't hash to its own hash")
+	assert.Nil(t, s.best, "Nothing is downloaded for a chain that doesn't check out")
+
+	assert.Nil(t, s.addHeaders(peer1, headers, bc))
+	assert.Len(t, s.queue, 3)
+	assert.Len(t, s.inFlight, 3)
+	assert.Equal(t, 3, len(peer1.queue)+len(peer2.queue), "One getdata per block")
+	assert.NotEmpty(t, peer1.queue, "Blocks are downloaded from both peers")
+	assert.NotEmpty(t, peer2.queue)
+
+	// Blocks are connected in order, whatever order they arrive in
+	s.blockReceived(peer1, blocks[2], bc)
+	s.blockReceived(peer2, blocks[1], bc)
+	assert.Equal(t, 0, bc.GetBestHeight())
+	s.blockReceived(peer1, blocks[0], bc)
+	assert.Equal(t, 3, bc.GetBestHeight())
+	assert.Empty(t, s.queue)
+	assert.Nil(t, s.best)
+
+	// A peer that doesn't deliver is dropped and its blocks asked for from another
+	bc = newNode()
+	s = newBlockSync()
+	peer2.BestHeight = 0
+	assert.Nil(t, s.addHeaders(peer1, headers, bc))
+	for key, request := range s.inFlight {
+		assert.Equal(t, peer1, request.peer, "Only peer 1 has the blocks")
+		s.inFlight[key] = blockRequest{request.peer, time.Now().Add(-2 * blockDownloadTimeout)}
+	}
+	peer2.BestHeight = 3
+	s.checkTimeouts()
+	assert.True(t, peer1.disconnecting())
+	assert.Len(t, s.inFlight, 3)
+	for _, request := range s.inFlight {
+		assert.Equal(t, peer2, request.peer)
+	}
+	for _, block := range blocks {
+		s.blockReceived(peer2, block, bc)
+	}
+	assert.Equal(t, 3, bc.GetBestHeight())
+}
@@ -42,16 +42,34 @@ func DeserializeTransaction(data []byte) Transaction {
 	return transaction
 }
 
-func (tx *Transaction) SetId() {
-	var encoded bytes.Buffer
-	encoder := gob.NewEncoder(&encoded)
+// hashData is the transaction in the fixed encoding it is hashed in, for its ID, signatures and the
+// merkle root. Its gob encoding can't be hashed, as the type numbers gob writes depend on what else
+// the process has encoded first.
+func (tx Transaction) hashData() []byte {
+	var buffer bytes.Buffer
+	writeBytes := func(data []byte) {
+		buffer.Write(Int64ToBytes(int64(len(data))))
+		buffer.Write(data)
+	}
 
-	if err := encoder.Encode(tx); err != nil {
-		log.Panic(err)
+	writeBytes(tx.ID)
+	buffer.Write(Int64ToBytes(int64(len(tx.Inputs))))
+	for _, input := range tx.Inputs {
+		writeBytes(input.TxOutputID)
+		buffer.Write(Int64ToBytes(int64(input.TxOutputIndex)))
+		writeBytes(input.Signature)
+		writeBytes(input.PubKey)
+	}
+	buffer.Write(Int64ToBytes(int64(len(tx.Outputs))))
+	for _, output := range tx.Outputs {
+		buffer.Write(Int64ToBytes(int64(output.Value)))
+		writeBytes(output.PubKeyHash)
 	}
+	return buffer.Bytes()
+}
 
-	sum256 := sha256.Sum256(encoded.Bytes())
-	tx.ID = sum256[:]
+func (tx *Transaction) SetId() {
+	tx.ID = tx.Hash()
 }
 
 func (tx Transaction) IsCoinbase() bool {
@@ -62,6 +80,9 @@ func (tx Transaction) IsCoinbase() bool {
 //   - the inputs
 //   - the outputs referenced by the inputs
 //   - the outputs
+//
+// Only the inputs that spend with key are signed, a transaction spending from several addresses is
+// signed once with each of their keys.
 func (tx *Transaction) Sign(key ecdsa.PrivateKey, prevTxs map[string]Transaction) {
 	if tx.IsCoinbase() {
 		return
@@ -69,6 +90,10 @@ func (tx *Transaction) Sign(key ecdsa.PrivateKey, prevTxs map[string]Transaction
 
 	txTrimmed := tx.TrimmedCopy()
 	for index, input := range txTrimmed.Inputs {
+		if !isKeyFor(key, tx.Inputs[index].PubKey) {
+			continue // another key signs this input
+		}
+
 		prevTx := prevTxs[hex.EncodeToString(input.TxOutputID)]
 		txTrimmed.Inputs[index].Signature = nil                                         // Blank the signature - it's not needed in the current tx sig
 		txTrimmed.Inputs[index].PubKey = prevTx.Outputs[input.TxOutputIndex].PubKeyHash // PubKeyHash of referenced output
@@ -81,12 +106,23 @@ func (tx *Transaction) Sign(key ecdsa.PrivateKey, prevTxs map[string]Transaction
 			log.Panic(err)
 		}
 
-		sig := append(r.Bytes(), s.Bytes()...)
+		// Pad r and s to full length, Verify splits the signature in half to get them back
+		sig := append(padCoordinate(r), padCoordinate(s)...)
 		tx.Inputs[index].Signature = sig // store the signature on the input
 	}
 
 }
 
+// isKeyFor reports whether key is the private key for pubKey, the public key an input spends with
+func isKeyFor(key ecdsa.PrivateKey, pubKey []byte) bool {
+	x := big.Int{}
+	y := big.Int{}
+	keyLen := len(pubKey)
+	x.SetBytes(pubKey[:(keyLen / 2)])
+	y.SetBytes(pubKey[(keyLen / 2):])
+	return key.X.Cmp(&x) == 0 && key.Y.Cmp(&y) == 0
+}
+
 // TrimmedCopy generates a lightweight version of a transction for signing purposes
 func (tx *Transaction) TrimmedCopy() Transaction {
 	var inputs []TxInput
@@ -163,13 +199,13 @@ func (tx *Transaction) Verify(prevTxs map[string]Transaction) bool {
 	return true
 }
 
-// Hash generates the SHA256 of the serialized transaction
+// Hash generates the SHA256 of the transaction without its ID
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 	txCopy := *tx
 	txCopy.ID = []byte{}
 
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash = sha256.Sum256(txCopy.hashData())
 	return hash[:]
 }
 
@@ -196,43 +232,62 @@ func NewCoinbaseTx(recipient, data string) *Transaction {
 	return &tx
 }
 
-func NewUtxoTransaction(wallet *Wallet, to string, amount int, utxoSet *UTXOSet) *Transaction {
+// NewUtxoTransaction pays every one of payments from one of the wallets in a single transaction,
+// funding it with outputs chosen by selector from from and its change addresses. Any change is sent
+// to a new change address created in wallets.
+func NewUtxoTransaction(wallets *Wallets, from string, payments []Payment, utxoSet *UTXOSet, selector CoinSelector, fees FeePolicy) *Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
-	pubKeyHash := HashPubKey(wallet.PublicKey)
-	available, spendableOutputs := utxoSet.FindSpendableOutputs(pubKeyHash, amount)
-	from := fmt.Sprintf("%s", wallet.GetAddress())
-	fmt.Printf("Found the required [%d] coins in [%s]\n", available, from)
-	if available < amount {
-		log.Panic("ERROR Not enough funds!")
-	}
-
-	for txID, outputIndices := range spendableOutputs { //outputs are offsets here (since we have the txID)
-		txID, _ := hex.DecodeString(txID)
-		for _, outputIndex := range outputIndices {
-			input := TxInput{
-				TxOutputID:    txID,
-				TxOutputIndex: outputIndex,
-				Signature:     nil,
-				PubKey:        wallet.PublicKey,
-			}
-			inputs = append(inputs, input)
+	var utxos []SpendableOutput
+	owners := make(map[string]Wallet) // the wallet holding the key for each pubKeyHash
+	for _, address := range wallets.GetSpendingAddresses(from) {
+		wallet := wallets.GetWallet(address)
+		pubKeyHash := HashPubKey(wallet.PublicKey)
+		owners[hex.EncodeToString(pubKeyHash)] = wallet
+		utxos = append(utxos, utxoSet.FindSpendableOutputs(pubKeyHash)...)
+	}
+	selection, err := selector.Select(utxos, PaymentsTotal(payments), len(payments), fees)
+	if err != nil {
+		log.Panicf("ERROR Unable to fund transaction from [%s]: %s", from, err)
+	}
+	fmt.Printf("Found the required [%d] coins in [%s] (%d inputs, fee %d)\n", selection.Total, from, len(selection.Outputs), selection.Fee)
+
+	var signers []Wallet
+	signed := make(map[string]bool)
+	for _, utxo := range selection.Outputs {
+		owner := hex.EncodeToString(utxo.Output.PubKeyHash)
+		wallet := owners[owner]
+		input := TxInput{
+			TxOutputID:    utxo.TxID,
+			TxOutputIndex: utxo.Index,
+			Signature:     nil,
+			PubKey:        wallet.PublicKey,
+		}
+		inputs = append(inputs, input)
+		if !signed[owner] {
+			signed[owner] = true
+			signers = append(signers, wallet)
 		}
 	}
 
-	// Build the outputs (one to receiver and one to sender as change)
-	fmt.Printf("Creating main txo [%d to %s]\n", amount, to)
-	outputs = append(outputs, *NewTXOutput(amount, to))
+	// Build the outputs (one per payment and one to a fresh change address)
+	for _, payment := range payments {
+		fmt.Printf("Creating main txo [%d to %s]\n", payment.Amount, payment.To)
+		outputs = append(outputs, *NewTXOutput(payment.Amount, payment.To))
+	}
 
-	if available > amount {
-		fmt.Printf("Creating change txo [%d to %s]\n", available-amount, from)
-		outputs = append(outputs, *NewTXOutput(available-amount, from))
+	if selection.Change > 0 {
+		changeAddress := wallets.CreateChangeWallet(from)
+		fmt.Printf("Creating change txo [%d to %s]\n", selection.Change, changeAddress)
+		outputs = append(outputs, *NewTXOutput(selection.Change, changeAddress))
 	}
 
 	tx := Transaction{ID: nil, Inputs: inputs, Outputs: outputs}
-	tx.ID = tx.Hash()
-	utxoSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey)
+	for _, wallet := range signers {
+		utxoSet.Blockchain.SignTransaction(&tx, wallet.PrivateKey) // each key signs the inputs it owns
+	}
+	tx.SetId() // the ID covers the signatures, so it is only known once every input is signed
 
 	return &tx
 }
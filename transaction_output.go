@@ -11,26 +11,39 @@ type TxOutput struct {
 	PubKeyHash []byte
 }
 
-type TxOutputs struct {
-	Outputs []TxOutput
+// UtxoEntry is an unspent output as stored in the chainstate, keyed by its outpoint (txid, vout)
+type UtxoEntry struct {
+	Value      int
+	PubKeyHash []byte
+	Height     int  // height of the block containing the output
+	Coinbase   bool // whether the output is a block reward
+}
+
+func NewUtxoEntry(output TxOutput, height int, coinbase bool) UtxoEntry {
+	return UtxoEntry{output.Value, output.PubKeyHash, height, coinbase}
+}
+
+// Output is the entry as the TxOutput it came from
+func (entry UtxoEntry) Output() TxOutput {
+	return TxOutput{entry.Value, entry.PubKeyHash}
 }
 
-func (outputs TxOutputs) Serialize() []byte {
+func (entry UtxoEntry) Serialize() []byte {
 	var buff bytes.Buffer
 	encoder := gob.NewEncoder(&buff)
-	if err := encoder.Encode(outputs); err != nil {
+	if err := encoder.Encode(entry); err != nil {
 		log.Panic(err)
 	}
 	return buff.Bytes()
 }
 
-func DeserializeOutputs(data []byte) TxOutputs {
-	var outputs TxOutputs
+func DeserializeUtxoEntry(data []byte) UtxoEntry {
+	var entry UtxoEntry
 	decoder := gob.NewDecoder(bytes.NewReader(data))
-	if err := decoder.Decode(&outputs); err != nil {
+	if err := decoder.Decode(&entry); err != nil {
 		log.Panic(err)
 	}
-	return outputs
+	return entry
 }
 
 func (out *TxOutput) IsLockedWithKey(pubKeyHash []byte) bool {
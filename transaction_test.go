@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestNewUtxoTransactionSendsChangeToAChangeAddress(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := newTestBlockchain(t, alice, false)
+	utxoSet := UTXOSet{bc}
+
+	tx := NewUtxoTransaction(wallets, alice, []Payment{{bob, 3}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+
+	assert.Len(t, tx.Outputs, 2)
+	assert.Equal(t, 3, tx.Outputs[0].Value)
+	assert.True(t, tx.Outputs[0].IsLockedWithKey(ConvertBase58AddressToPubKeyHash(bob)))
+
+	change := wallets.GetSpendingAddresses(alice)
+	assert.Len(t, change, 2, "A change address was created for Alice")
+	assert.Equal(t, 7, tx.Outputs[1].Value)
+	assert.True(t, tx.Outputs[1].IsLockedWithKey(ConvertBase58AddressToPubKeyHash(change[1])))
+	assert.False(t, tx.Outputs[1].IsLockedWithKey(ConvertBase58AddressToPubKeyHash(alice)), "Change does not go back to Alice")
+	assert.True(t, bc.VerifyTransaction(tx))
+}
+
+func TestNewUtxoTransactionSpendsFromChangeAddresses(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := newTestBlockchain(t, alice, false)
+	utxoSet := UTXOSet{bc}
+
+	mineTx(bc, alice, NewUtxoTransaction(wallets, alice, []Payment{{bob, 3}}, &utxoSet, LargestFirstSelector{}, FeePolicy{}))
+	change := wallets.GetSpendingAddresses(alice)[1]
+	assert.Equal(t, 10, balanceOf(utxoSet, alice), "Only the block reward is left at Alice's own address")
+	assert.Equal(t, 7, balanceOf(utxoSet, change))
+
+	// 15 is more than Alice's own address holds, so the change output has to be spent too
+	tx := NewUtxoTransaction(wallets, alice, []Payment{{bob, 15}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	spendsChange := false
+	for _, input := range tx.Inputs {
+		if bytes.Equal(HashPubKey(input.PubKey), ConvertBase58AddressToPubKeyHash(change)) {
+			spendsChange = true
+		}
+	}
+	assert.True(t, spendsChange)
+	assert.Len(t, tx.Inputs, 2)
+	assert.True(t, bc.VerifyTransaction(tx), "Each input is signed by the key of the address it spends from")
+
+	mineTx(bc, bob, tx)
+	assert.Equal(t, 3+15+10, balanceOf(utxoSet, bob), "Bob has both payments and the block reward")
+	assert.Equal(t, 0, balanceOf(utxoSet, alice)+balanceOf(utxoSet, change))
+}
+
+func TestTransactionHashIsFixed(t *testing.T) {
+	// The hash mustn't depend on the process, so a fixed transaction always has the same ID
+	tx := Transaction{
+		Inputs:  []TxInput{{[]byte{1, 2, 3}, 1, []byte{4, 5}, []byte{6}}},
+		Outputs: []TxOutput{{10, []byte{7, 8}}},
+	}
+	tx.SetId()
+	assert.Equal(t, "51a7ea5d56155a3e9216d52ee6ddd3c3c951c53598556dd920658ff1ae1c46b3", hex.EncodeToString(tx.ID))
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"log"
+)
+
+// The transaction index is optional: when it is enabled it maps the ID of every transaction on
+// the main chain to where it can be found, so FindTx doesn't have to walk the chain.
+
+// TxLocation is the block a transaction is in and its position within the block
+type TxLocation struct {
+	BlockHash []byte
+	Position  int
+}
+
+func (location TxLocation) Serialize() []byte {
+	var buff bytes.Buffer
+	encoder := gob.NewEncoder(&buff)
+	if err := encoder.Encode(location); err != nil {
+		log.Panic(err)
+	}
+	return buff.Bytes()
+}
+
+func DeserializeTxLocation(data []byte) TxLocation {
+	var location TxLocation
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	if err := decoder.Decode(&location); err != nil {
+		log.Panic(err)
+	}
+	return location
+}
+
+func indexBlockTxs(tx ChainTx, block *Block) error {
+	for position, transaction := range block.Transactions {
+		if err := tx.PutTxLocation(transaction.ID, TxLocation{block.Hash, position}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func unindexBlockTxs(tx ChainTx, block *Block) error {
+	for _, transaction := range block.Transactions {
+		if err := tx.DeleteTxLocation(transaction.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// HasTxIndex reports whether the transaction index is enabled for this chain
+func (blockchain *Blockchain) HasTxIndex() bool {
+	enabled := false
+	err := blockchain.db.View(func(tx ChainTx) error {
+		enabled = tx.HasTxIndex()
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return enabled
+}
+
+func (blockchain *Blockchain) findIndexedTx(ID []byte) (Transaction, error) {
+	var transaction Transaction
+	err := blockchain.db.View(func(tx ChainTx) error {
+		location := tx.GetTxLocation(ID)
+		if location == nil {
+			return errors.New("transaction not found")
+		}
+
+		block := tx.GetBlock(location.BlockHash)
+		if block == nil {
+			return errors.New("transaction is in a block that has been pruned")
+		}
+		if location.Position >= len(block.Transactions) || !bytes.Equal(block.Transactions[location.Position].ID, ID) {
+			return errors.New("transaction index is out of date")
+		}
+		transaction = *block.Transactions[location.Position]
+		return nil
+	})
+	return transaction, err
+}
+
+// ReindexTxs (re)builds the transaction index from the main chain, enabling it if necessary
+func (blockchain *Blockchain) ReindexTxs() {
+	err := blockchain.db.Update(func(tx ChainTx) error {
+		if err := tx.DropTxIndex(); err != nil {
+			return err
+		}
+		if err := tx.CreateTxIndex(); err != nil {
+			return err
+		}
+
+		// Walk the headers so the index can be rebuilt on a pruned chain (pruned blocks are skipped)
+		hash := tx.GetTip()
+		for len(hash) > 0 {
+			header := tx.GetHeader(hash)
+			if header == nil {
+				return errors.New("main chain is missing blocks, unable to index transactions")
+			}
+			if block := tx.GetBlock(hash); block != nil {
+				if err := indexBlockTxs(tx, block); err != nil {
+					return err
+				}
+			}
+			hash = header.PrevBlockHash
+		}
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
+
+// DropTxIndex disables the transaction index, so FindTx goes back to walking the chain
+func (blockchain *Blockchain) DropTxIndex() {
+	err := blockchain.db.Update(func(tx ChainTx) error {
+		return tx.DropTxIndex()
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+}
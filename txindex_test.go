@@ -0,0 +1,83 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func assertTxIndexed(t *testing.T, bc *Blockchain, block *Block, indexed bool) {
+	for _, tx := range block.Transactions {
+		found, err := bc.findIndexedTx(tx.ID)
+		if indexed {
+			assert.Nil(t, err)
+			assert.Equal(t, tx.ID, found.ID)
+		} else {
+			assert.NotNil(t, err, "Block at height %d is off the main chain, so its transactions aren't indexed", block.Height)
+		}
+	}
+}
+
+func TestTxIndex(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := newTestBlockchain(t, alice, true)
+	utxoSet := UTXOSet{bc}
+	genesis := tipBlock(t, bc)
+
+	tx := NewUtxoTransaction(wallets, alice, []Payment{{bob, 3}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	block := mineTx(bc, alice, tx)
+	assert.True(t, bc.HasTxIndex())
+	assertTxIndexed(t, bc, genesis, true)
+	assertTxIndexed(t, bc, block, true)
+	_, err := bc.findIndexedTx([]byte("missing"))
+	assert.NotNil(t, err)
+
+	bc.DropTxIndex()
+	assert.False(t, bc.HasTxIndex())
+	found, err := bc.FindTx(tx.ID)
+	assert.Nil(t, err, "FindTx walks the chain without the index")
+	assert.Equal(t, tx.ID, found.ID)
+
+	bc.ReindexTxs()
+	assert.True(t, bc.HasTxIndex())
+	assertTxIndexed(t, bc, genesis, true)
+	assertTxIndexed(t, bc, block, true)
+}
+
+func TestTxIndexFollowsReorg(t *testing.T) {
+	_, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := newTestBlockchain(t, alice, true)
+	genesis := tipBlock(t, bc)
+
+	a1 := newTestBlock(alice, genesis)
+	bc.AddBlock(a1)
+	assertTxIndexed(t, bc, a1, true)
+
+	// A side branch isn't indexed until it becomes the main chain
+	b1 := newTestBlock(bob, genesis)
+	bc.AddBlock(b1)
+	assertTxIndexed(t, bc, b1, false)
+
+	b2 := newTestBlock(bob, b1)
+	bc.AddBlock(b2)
+	assert.Equal(t, b2.Hash, bc.tip)
+	assertTxIndexed(t, bc, a1, false)
+	assertTxIndexed(t, bc, b1, true)
+	assertTxIndexed(t, bc, b2, true)
+
+	// A branch whose blocks haven't all arrived moves the tip but leaves the index for a reindex
+	c2 := newTestBlock(alice, b1)
+	c3 := newTestBlock(alice, c2)
+	bc.AddBlock(c3)
+	assert.Equal(t, c3.Hash, bc.tip)
+	assertTxIndexed(t, bc, b2, true)
+	assertTxIndexed(t, bc, c3, false)
+
+	bc.AddBlock(c2)
+	bc.ReindexTxs()
+	assertTxIndexed(t, bc, b1, true)
+	assertTxIndexed(t, bc, b2, false)
+	assertTxIndexed(t, bc, c2, true)
+	assertTxIndexed(t, bc, c3, true)
+}
@@ -15,6 +15,10 @@ func Int64ToBytes(i int64) []byte {
 	return buf.Bytes()
 }
 
+func BytesToInt64(data []byte) int64 {
+	return int64(binary.BigEndian.Uint64(data))
+}
+
 func ReverseBytes(data []byte) {
 	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
 		data[i], data[j] = data[j], data[i]
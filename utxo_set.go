@@ -1,133 +1,190 @@
 package main
 
 import (
+	"encoding/binary"
 	"encoding/hex"
-	"github.com/boltdb/bolt"
+	"errors"
 	"log"
 )
 
-const utxoBucketName = "chainstate"
+// The chainstate holds one entry per unspent output, keyed by its outpoint: the ID of the
+// transaction that created it followed by its index (vout) within that transaction's outputs
+// as a 4 byte big endian integer. Spending an output deletes just its own entry, so the index
+// an input references always identifies the same output.
+const txIDLen = 32
 
 type UTXOSet struct {
 	Blockchain *Blockchain
 }
 
+func outpointKey(txID []byte, index int) []byte {
+	key := make([]byte, len(txID)+4)
+	copy(key, txID)
+	binary.BigEndian.PutUint32(key[len(txID):], uint32(index))
+	return key
+}
+
+func splitOutpointKey(key []byte) ([]byte, int) {
+	split := len(key) - 4
+	return append([]byte{}, key[:split]...), int(binary.BigEndian.Uint32(key[split:]))
+}
+
 func (us UTXOSet) Reindex() {
-	db := us.Blockchain.db
-	bucketName := []byte(utxoBucketName)
+	if us.Blockchain.IsPruned() {
+		log.Panic("ERROR: Unable to rebuild the UTXO set of a pruned chain")
+	}
 
-	db.Update(func(tx *bolt.Tx) error {
-		tx.DeleteBucket(bucketName)
-		_, _ = tx.CreateBucket(bucketName)
-		return nil
-	})
+	utxoMap := us.Blockchain.BuildUtxoMap()
 
-	utxoMap := us.Blockchain.BuildTransactionUtxoMap()
+	err := us.Blockchain.db.Update(func(tx ChainTx) error {
+		if err := tx.DeleteChainstate(); err != nil {
+			return err
+		}
+		for outpoint, entry := range utxoMap {
+			key, _ := hex.DecodeString(outpoint)
+			if err := tx.PutUtxo(key, entry); err != nil {
+				return err
+			}
+		}
 
-	db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(bucketName)
-		for txID, utxos := range utxoMap {
-			key, _ := hex.DecodeString(txID)
-			bucket.Put(key, utxos.Serialize())
+		hash := tx.GetTip()
+		for len(hash) > 0 {
+			block := tx.GetBlock(hash)
+			for _, transaction := range block.Transactions {
+				if err := indexTxHistory(tx, transaction, block.Height); err != nil {
+					return err
+				}
+			}
+			hash = block.PrevBlockHash
 		}
 		return nil
 	})
+	if err != nil {
+		log.Panic(err)
+	}
 }
 
-func (us UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	spendableOutputs := make(map[string][]int)
-	acc := 0
+// GetUtxo looks up a single unspent output by its outpoint
+func (us UTXOSet) GetUtxo(txID []byte, index int) (UtxoEntry, bool) {
+	var entry UtxoEntry
+	found := false
+	err := us.Blockchain.db.View(func(tx ChainTx) error {
+		entry, found = tx.GetUtxo(outpointKey(txID, index))
+		return nil
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return entry, found
+}
 
-	db := us.Blockchain.db
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucketName))
-		cursor := bucket.Cursor()
-
-		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
-			txID := hex.EncodeToString(key)
-			outputs := DeserializeOutputs(value)
-
-			for offset, output := range outputs.Outputs {
-				if output.IsLockedWithKey(pubKeyHash) && acc < amount {
-					acc = acc + output.Value
-					spendableOutputs[txID] = append(spendableOutputs[txID], offset)
-				}
+// FindUnspentTx rebuilds as much of transaction txID as the UTXO set knows: its unspent outputs,
+// at their original indices (spent outputs are left zero). This is enough to sign or verify an
+// input spending it when the block holding the transaction has been pruned.
+func (us UTXOSet) FindUnspentTx(txID []byte) (Transaction, error) {
+	transaction := Transaction{ID: txID}
+	err := us.Blockchain.db.View(func(tx ChainTx) error {
+		err := tx.ForEachUtxoOfTx(txID, func(outpoint []byte, entry UtxoEntry) error {
+			_, index := splitOutpointKey(outpoint)
+			for len(transaction.Outputs) <= index {
+				transaction.Outputs = append(transaction.Outputs, TxOutput{})
 			}
+			transaction.Outputs[index] = entry.Output()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(transaction.Outputs) == 0 {
+			return errors.New("transaction has no unspent outputs")
 		}
 		return nil
 	})
+	return transaction, err
+}
+
+// FindSpendableOutputs collects every unspent output locked with pubKeyHash for a CoinSelector to choose from
+func (us UTXOSet) FindSpendableOutputs(pubKeyHash []byte) []SpendableOutput {
+	var spendableOutputs []SpendableOutput
+
+	db := us.Blockchain.db
+	err := db.View(func(tx ChainTx) error {
+		return tx.ForEachUtxoOf(pubKeyHash, func(outpoint []byte, entry UtxoEntry) error {
+			txID, index := splitOutpointKey(outpoint)
+			spendableOutputs = append(spendableOutputs, SpendableOutput{txID, index, entry.Output()})
+			return nil
+		})
+	})
 	if err != nil {
 		log.Panic(err)
 	}
 
-	return acc, spendableOutputs
+	return spendableOutputs
 }
+
 func (us UTXOSet) FindUtxos(pubKeyHash []byte) []TxOutput {
 	var utxos []TxOutput
 	db := us.Blockchain.db
-	err := db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucketName))
-		cursor := bucket.Cursor()
-
-		for key, value := cursor.First(); key != nil; key, value = cursor.Next() {
-			outputs := DeserializeOutputs(value)
+	err := db.View(func(tx ChainTx) error {
+		return tx.ForEachUtxoOf(pubKeyHash, func(_ []byte, entry UtxoEntry) error {
+			utxos = append(utxos, entry.Output())
+			return nil
+		})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return utxos
+}
 
-			for _, output := range outputs.Outputs {
-				if output.IsLockedWithKey(pubKeyHash) {
-					utxos = append(utxos, output)
-				}
-			}
-		}
+// FindHistory returns every main chain transaction that paid to or spent from pubKeyHash, oldest first
+func (us UTXOSet) FindHistory(pubKeyHash []byte) []AddressHistoryEntry {
+	var history []AddressHistoryEntry
+	db := us.Blockchain.db
+	err := db.View(func(tx ChainTx) error {
+		history = tx.GetHistory(pubKeyHash)
 		return nil
 	})
 	if err != nil {
 		log.Panic(err)
 	}
-	return utxos
+	return history
 }
 
-// Update Having the UTXO set means that our data (transactions) are now split into two storages:
+// updateUtxos applies block to the UTXO set and address indexes inside tx. Having the UTXO set
+// means that our data (transactions) are now split into two storages:
 //
 //	actual transactions are stored in the blockchain,
 //	and unspent outputs are stored in the UTXO set.
 //
-// Such separation requires solid synchronization mechanism because we want the UTXO set to
-// always be updated and store outputs of most recent transactions
-func (us UTXOSet) Update(block *Block) {
-	db := us.Blockchain.db
-
-	db.Update(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte(utxoBucketName))
-		for _, tx := range block.Transactions {
-			if tx.IsCoinbase() == false {
-				for _, input := range tx.Inputs {
-					var updatedOutputs TxOutputs
-					data := bucket.Get(input.TxOutputID)
-					outputs := DeserializeOutputs(data)
-
-					// carry over all outputs that have not been referenced by an input
-					for offset, output := range outputs.Outputs {
-						if input.TxOutputIndex != offset {
-							updatedOutputs.Outputs = append(updatedOutputs.Outputs, output)
-						}
-					}
-
-					// If there are no unspent outputs for a tx, then remove them from the utxo chainstate
-					if len(updatedOutputs.Outputs) == 0 {
-						bucket.Delete(input.TxOutputID)
-					} else {
-						bucket.Put(input.TxOutputID, updatedOutputs.Serialize())
-					}
+// Such separation requires solid synchronization mechanism, so blocks are applied in the same
+// update that makes them the tip
+func updateUtxos(tx ChainTx, block *Block) error {
+	for _, transaction := range block.Transactions {
+		if transaction.IsCoinbase() == false {
+			for _, input := range transaction.Inputs {
+				// Remove the output referenced by the input from the utxo chainstate
+				key := outpointKey(input.TxOutputID, input.TxOutputIndex)
+				if _, found := tx.GetUtxo(key); !found {
+					return errors.New("transaction spends an output that is not in the UTXO set")
+				}
+				if err := tx.DeleteUtxo(key); err != nil {
+					return err
 				}
 			}
+		}
 
-			// Now add the outputs from the latest tx (being added in this block)
-			outputsForNewTx := TxOutputs{}
-			for _, output := range tx.Outputs {
-				outputsForNewTx.Outputs = append(outputsForNewTx.Outputs, output)
+		// Now add the outputs from the latest tx (being added in this block)
+		for index, output := range transaction.Outputs {
+			entry := NewUtxoEntry(output, block.Height, transaction.IsCoinbase())
+			if err := tx.PutUtxo(outpointKey(transaction.ID, index), entry); err != nil {
+				return err
 			}
-			bucket.Put(tx.ID, outputsForNewTx.Serialize())
 		}
-		return nil
-	})
+
+		if err := indexTxHistory(tx, transaction, block.Height); err != nil {
+			return err
+		}
+	}
+	return nil
 }
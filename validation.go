@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+var errUnknownPrevTx = errors.New("spends unknown transaction")
+var errSpentOutput = errors.New("spends an output that isn't in the UTXO set")
+
+// maxFutureBlockTime is how far ahead of our clock a block's timestamp may be
+const maxFutureBlockTime = 2 * time.Hour
+
+// CheckHeader validates a header before its block is downloaded: its proof of work, and that it
+// follows parent at the right height with a timestamp that isn't too far in the future
+func CheckHeader(header, parent *BlockHeader) error {
+	if err := checkHeaderProofOfWork(header); err != nil {
+		return err
+	}
+	if !bytes.Equal(header.PrevBlockHash, parent.Hash) {
+		return fmt.Errorf("header %x doesn't follow %x", header.Hash, parent.Hash)
+	}
+	if header.Height != parent.Height+1 {
+		return fmt.Errorf("header %x has height %d, expected %d", header.Hash, header.Height, parent.Height+1)
+	}
+	if time.Unix(header.Timestamp, 0).After(time.Now().Add(maxFutureBlockTime)) {
+		return fmt.Errorf("header %x is timestamped too far in the future", header.Hash)
+	}
+	return nil
+}
+
+// checkHeaderProofOfWork checks the header hash is the proof of work hash and meets the target
+func checkHeaderProofOfWork(header *BlockHeader) error {
+	hash := sha256.Sum256(powData(header.Timestamp, header.MerkleRoot, header.PrevBlockHash, header.Nonce))
+	if !bytes.Equal(hash[:], header.Hash) {
+		return fmt.Errorf("header %x doesn't hash to its own hash", header.Hash)
+	}
+	if new(big.Int).SetBytes(hash[:]).Cmp(powTarget()) != -1 {
+		return fmt.Errorf("header %x doesn't meet the proof of work target", header.Hash)
+	}
+	return nil
+}
+
+// CheckProofOfWork checks the block hash is the proof of work hash and meets the target
+func CheckProofOfWork(block *Block) error {
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("block %x has no transactions", block.Hash)
+	}
+	pow := NewProofOfWork(block)
+	hash := sha256.Sum256(pow.prepareData(block.Nonce))
+	if !bytes.Equal(hash[:], block.Hash) {
+		return fmt.Errorf("block %x doesn't hash to its own hash", block.Hash)
+	}
+	if !pow.Validate() {
+		return fmt.Errorf("block %x doesn't meet the proof of work target", block.Hash)
+	}
+	return nil
+}
+
+// CheckBlock validates a block before it is connected: its proof of work, that it follows a
+// block we know at the right height, that every transaction spends outputs that exist with valid
+// signatures and no more than their value, and that the coinbase pays no more than the subsidy.
+// Transactions may spend outputs of earlier transactions in the block.
+func (blockchain *Blockchain) CheckBlock(block *Block) error {
+	if err := CheckProofOfWork(block); err != nil {
+		return err
+	}
+
+	if len(block.PrevBlockHash) == 0 {
+		return fmt.Errorf("block %x is a genesis block for another chain", block.Hash)
+	}
+	parent, err := blockchain.GetBlockHeader(block.PrevBlockHash)
+	if err != nil {
+		return fmt.Errorf("block %x doesn't follow a known block", block.Hash)
+	}
+	if block.Height != parent.Height+1 {
+		return fmt.Errorf("block %x has height %d, expected %d", block.Hash, block.Height, parent.Height+1)
+	}
+
+	// The chainstate is only the state a block builds on if it extends the tip. A block on another
+	// branch is checked against the chainstate again if a reorganisation rebuilds it.
+	extendsTip := bytes.Equal(block.PrevBlockHash, blockchain.tip)
+
+	coinbases := 0
+	blockTxs := make(map[string]Transaction)
+	spent := make(map[string]bool)
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			coinbases++
+			if !bytes.Equal(tx.ID, tx.Hash()) {
+				return fmt.Errorf("block %x has a coinbase that doesn't hash to its ID %x", block.Hash, tx.ID)
+			}
+			if value, err := outputsValue(tx); err != nil {
+				return fmt.Errorf("block %x: %s", block.Hash, err)
+			} else if value > blockSubsidy {
+				return fmt.Errorf("block %x has a coinbase paying %d, more than the subsidy of %d", block.Hash, value, blockSubsidy)
+			}
+		} else if err := blockchain.checkTransaction(tx, blockTxs); err != nil && (extendsTip || !errors.Is(err, errSpentOutput)) {
+			return fmt.Errorf("block %x: %s", block.Hash, err)
+		} else {
+			for _, input := range tx.Inputs {
+				outpoint := hex.EncodeToString(outpointKey(input.TxOutputID, input.TxOutputIndex))
+				if spent[outpoint] {
+					return fmt.Errorf("block %x spends %x:%d twice", block.Hash, input.TxOutputID, input.TxOutputIndex)
+				}
+				spent[outpoint] = true
+			}
+		}
+		blockTxs[hex.EncodeToString(tx.ID)] = *tx
+	}
+	if coinbases != 1 {
+		return fmt.Errorf("block %x has %d coinbase transactions", block.Hash, coinbases)
+	}
+	return nil
+}
+
+// checkTransaction verifies a non coinbase transaction: that it hashes to its ID, that each input
+// is signed by the key its output is locked to, and that it spends no more than the outputs it
+// spends. The transactions it spends are looked for in pending first and then in the chain, and
+// outputs of transactions in the chain must still be in the UTXO set.
+func (blockchain *Blockchain) checkTransaction(tx *Transaction, pending map[string]Transaction) error {
+	if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+		return fmt.Errorf("transaction %x has no inputs or outputs", tx.ID)
+	}
+	if !bytes.Equal(tx.ID, tx.Hash()) {
+		return fmt.Errorf("transaction %x doesn't hash to its ID", tx.ID)
+	}
+	outputs, err := outputsValue(tx)
+	if err != nil {
+		return err
+	}
+
+	inputs := 0
+	prevTxs := make(map[string]Transaction)
+	spent := make(map[string]bool)
+	var unspent []TxInput // inputs spending outputs in the chain, which must be in the UTXO set
+	for _, input := range tx.Inputs {
+		txID := hex.EncodeToString(input.TxOutputID)
+		outpoint := hex.EncodeToString(outpointKey(input.TxOutputID, input.TxOutputIndex))
+		if spent[outpoint] {
+			return fmt.Errorf("transaction %x spends %s:%d twice", tx.ID, txID, input.TxOutputIndex)
+		}
+		spent[outpoint] = true
+		prevTx, ok := pending[txID]
+		if !ok {
+			var err error
+			if prevTx, err = blockchain.findPrevTx(input.TxOutputID); err != nil {
+				return fmt.Errorf("transaction %x %w %s", tx.ID, errUnknownPrevTx, txID)
+			}
+			unspent = append(unspent, input)
+		}
+		if input.TxOutputIndex < 0 || input.TxOutputIndex >= len(prevTx.Outputs) {
+			return fmt.Errorf("transaction %x spends a missing output %s:%d", tx.ID, txID, input.TxOutputIndex)
+		}
+		if len(input.Signature) == 0 || len(input.PubKey) == 0 {
+			return fmt.Errorf("transaction %x has an unsigned input", tx.ID)
+		}
+		if !bytes.Equal(HashPubKey(input.PubKey), prevTx.Outputs[input.TxOutputIndex].PubKeyHash) {
+			return fmt.Errorf("transaction %x spends %s:%d which is locked to another key", tx.ID, txID, input.TxOutputIndex)
+		}
+		prevTxs[txID] = prevTx
+		inputs = inputs + prevTx.Outputs[input.TxOutputIndex].Value
+	}
+	if outputs > inputs {
+		return fmt.Errorf("transaction %x pays out %d but only spends %d", tx.ID, outputs, inputs)
+	}
+	if !tx.Verify(prevTxs) {
+		return fmt.Errorf("transaction %x has an invalid signature", tx.ID)
+	}
+
+	// Checked last, so callers looking at blocks the UTXO set has moved past can ignore errSpentOutput
+	utxoSet := UTXOSet{blockchain}
+	for _, input := range unspent {
+		if _, found := utxoSet.GetUtxo(input.TxOutputID, input.TxOutputIndex); !found {
+			return fmt.Errorf("transaction %x %w %x:%d", tx.ID, errSpentOutput, input.TxOutputID, input.TxOutputIndex)
+		}
+	}
+	return nil
+}
+
+// maxOutputValue bounds each output, so the total of a transaction's outputs can't overflow
+const maxOutputValue = 1 << 40
+
+// outputsValue adds up the outputs of a transaction, each of which must pay a positive amount
+func outputsValue(tx *Transaction) (int, error) {
+	total := 0
+	for index, output := range tx.Outputs {
+		if output.Value <= 0 || output.Value > maxOutputValue {
+			return 0, fmt.Errorf("transaction %x output %d has an invalid value %d", tx.ID, index, output.Value)
+		}
+		total = total + output.Value
+	}
+	return total, nil
+}
+
+// Levels of verifychain, each doing everything the levels below it do
+const (
+	verifyLevelProofOfWork = iota // proof of work and block hashes
+	verifyLevelLinks              // prev hash links, heights, stored headers and the height index
+	verifyLevelMerkle             // merkle roots of the transactions
+	verifyLevelSignatures         // transaction signatures
+	verifyLevelChainstate         // the stored chainstate matches one rebuilt from the blocks
+)
+
+const maxVerifyLevel = verifyLevelChainstate
+
+// VerifyChain checks the depth most recent main chain blocks (every block if depth is 0) at the
+// given level, returning how many blocks were checked and the first inconsistency found.
+// Blocks that have been pruned are not checked.
+func (blockchain *Blockchain) VerifyChain(depth, level int) (int, error) {
+	checked := 0
+	iterator := blockchain.Iterator()
+	var child *Block
+	for depth == 0 || checked < depth {
+		block := iterator.Next()
+		if block == nil {
+			break // reached the pruned blocks
+		}
+		if err := blockchain.verifyBlock(block, child, level); err != nil {
+			return checked, err
+		}
+		checked++
+		if len(block.PrevBlockHash) == 0 {
+			break
+		}
+		child = block
+	}
+
+	// The chainstate of a pruned chain can't be rebuilt to compare against
+	if level >= verifyLevelChainstate && !blockchain.IsPruned() {
+		if err := blockchain.verifyChainstate(); err != nil {
+			return checked, err
+		}
+	}
+	return checked, nil
+}
+
+// verifyBlock checks one main chain block. child is the block after it, or nil for the tip.
+func (blockchain *Blockchain) verifyBlock(block, child *Block, level int) error {
+	if err := CheckProofOfWork(block); err != nil {
+		return err
+	}
+	if level < verifyLevelLinks {
+		return nil
+	}
+
+	if child != nil && !bytes.Equal(child.PrevBlockHash, block.Hash) {
+		return fmt.Errorf("block %x at height %d doesn't link to the block before it", child.Hash, child.Height)
+	}
+	if child != nil && child.Height != block.Height+1 {
+		return fmt.Errorf("block %x has height %d but follows height %d", child.Hash, child.Height, block.Height)
+	}
+	if len(block.PrevBlockHash) == 0 && block.Height != 0 {
+		return fmt.Errorf("genesis block %x has height %d", block.Hash, block.Height)
+	}
+	hash, err := blockchain.GetBlockHashByHeight(block.Height)
+	if err != nil || !bytes.Equal(hash, block.Hash) {
+		return fmt.Errorf("height index doesn't point to block %x at height %d", block.Hash, block.Height)
+	}
+	header, err := blockchain.GetBlockHeader(block.Hash)
+	if err != nil {
+		return fmt.Errorf("block %x has no stored header", block.Hash)
+	}
+	if header.Height != block.Height || !bytes.Equal(header.PrevBlockHash, block.PrevBlockHash) ||
+		header.Nonce != block.Nonce || header.Timestamp != block.Timestamp {
+		return fmt.Errorf("stored header of block %x doesn't match the block", block.Hash)
+	}
+	if level < verifyLevelMerkle {
+		return nil
+	}
+
+	if len(block.Transactions) == 0 {
+		return fmt.Errorf("block %x has no transactions", block.Hash)
+	}
+	if !bytes.Equal(header.MerkleRoot, block.HashTransactions()) {
+		return fmt.Errorf("merkle root of block %x doesn't match its transactions", block.Hash)
+	}
+	if level < verifyLevelSignatures {
+		return nil
+	}
+
+	blockTxs := make(map[string]Transaction)
+	for _, tx := range block.Transactions {
+		blockTxs[hex.EncodeToString(tx.ID)] = *tx
+	}
+	pruned := blockchain.IsPruned()
+	for _, tx := range block.Transactions {
+		if tx.IsCoinbase() {
+			continue
+		}
+		err := blockchain.checkTransaction(tx, blockTxs)
+		if errors.Is(err, errUnknownPrevTx) && pruned {
+			continue // spends an output that was in a pruned block and has since been spent
+		}
+		if errors.Is(err, errSpentOutput) {
+			continue // the block has been connected, so the outputs it spends have left the UTXO set
+		}
+		if err != nil {
+			return fmt.Errorf("block %x at height %d: %s", block.Hash, block.Height, err)
+		}
+	}
+	return nil
+}
+
+// verifyChainstate compares the stored chainstate with one rebuilt from the blocks
+func (blockchain *Blockchain) verifyChainstate() error {
+	rebuilt := blockchain.BuildUtxoMap()
+	stored := 0
+	err := blockchain.db.View(func(tx ChainTx) error {
+		return tx.ForEachUtxo(func(key []byte, entry UtxoEntry) error {
+			outpoint := hex.EncodeToString(key)
+			expected, ok := rebuilt[outpoint]
+			if !ok {
+				return fmt.Errorf("chainstate has output %s which the chain has spent or never created", outpoint)
+			}
+			if entry.Value != expected.Value || entry.Height != expected.Height ||
+				entry.Coinbase != expected.Coinbase || !bytes.Equal(entry.PubKeyHash, expected.PubKeyHash) {
+				return fmt.Errorf("chainstate entry for output %s doesn't match the chain", outpoint)
+			}
+			stored++
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+	if stored != len(rebuilt) {
+		return fmt.Errorf("chainstate is missing %d unspent outputs", len(rebuilt)-stored)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVerifyChain(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := CreateBlockchainInStore(alice, NewMemoryStore(), true)
+	utxoSet := UTXOSet{bc}
+	utxoSet.Reindex()
+	tx := NewUtxoTransaction(wallets, alice, []Payment{{bob, 4}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	block := mineTx(bc, alice, tx)
+
+	checked, err := bc.VerifyChain(0, maxVerifyLevel)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, checked)
+
+	// Lose an unspent output from the chainstate
+	bc.db.Update(func(tx ChainTx) error {
+		return tx.DeleteUtxo(outpointKey(block.Transactions[0].ID, 0))
+	})
+	_, err = bc.VerifyChain(1, verifyLevelSignatures)
+	assert.Nil(t, err, "Lower levels don't look at the chainstate")
+	_, err = bc.VerifyChain(1, verifyLevelChainstate)
+	assert.ErrorContains(t, err, "chainstate is missing 1 unspent outputs")
+
+	// Point the height index at the wrong block
+	bc.db.Update(func(tx ChainTx) error {
+		return tx.PutHashAtHeight(1, block.PrevBlockHash)
+	})
+	checked, err = bc.VerifyChain(0, verifyLevelLinks)
+	assert.ErrorContains(t, err, "height index")
+	assert.Equal(t, 0, checked, "The first block checked is the inconsistent one")
+}
+
+func TestCheckBlockRejectsCreatingCoins(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := CreateBlockchainInStore(alice, NewMemoryStore(), false)
+	utxoSet := UTXOSet{bc}
+	utxoSet.Reindex()
+
+	// Alice re-signs a payment to pay out more than the 10 she spends
+	tx := NewUtxoTransaction(wallets, alice, []Payment{{bob, 4}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	tx.Outputs[0].Value = 40
+	bc.SignTransaction(tx, wallets.GetWallet(alice).PrivateKey)
+	tx.SetId()
+	block := NewBlock([]*Transaction{NewCoinbaseTx(alice, "block 1"), tx}, bc.tip, 1)
+	assert.ErrorContains(t, bc.CheckBlock(block), "pays out")
+
+	coinbase := NewCoinbaseTx(alice, "block 1")
+	coinbase.Outputs[0].Value = blockSubsidy + 1
+	coinbase.SetId()
+	block = NewBlock([]*Transaction{coinbase}, bc.tip, 1)
+	assert.ErrorContains(t, bc.CheckBlock(block), "more than the subsidy")
+
+	coinbase.Outputs[0].Value = -1
+	coinbase.SetId()
+	block = NewBlock([]*Transaction{coinbase}, bc.tip, 1)
+	assert.ErrorContains(t, bc.CheckBlock(block), "invalid value")
+}
+
+func TestCheckTransaction(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+	bc := CreateBlockchainInStore(alice, NewMemoryStore(), false)
+	utxoSet := UTXOSet{bc}
+	utxoSet.Reindex()
+	genesis := tipBlock(t, bc)
+
+	// Bob signs a spend of Alice's genesis reward with his own key
+	theft := &Transaction{
+		Inputs:  []TxInput{{TxOutputID: genesis.Transactions[0].ID, PubKey: wallets.GetWallet(bob).PublicKey}},
+		Outputs: []TxOutput{*NewTXOutput(10, bob)},
+	}
+	bc.SignTransaction(theft, wallets.GetWallet(bob).PrivateKey)
+	theft.SetId()
+	assert.ErrorContains(t, bc.checkTransaction(theft, nil), "locked to another key")
+
+	tx := NewUtxoTransaction(wallets, alice, []Payment{{bob, 3}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	doubleSpend := NewUtxoTransaction(wallets, alice, []Payment{{bob, 4}}, &utxoSet, LargestFirstSelector{}, FeePolicy{})
+	assert.Nil(t, bc.checkTransaction(tx, nil))
+
+	forged := *tx
+	forged.ID = doubleSpend.ID
+	assert.ErrorContains(t, bc.checkTransaction(&forged, nil), "doesn't hash to its ID")
+
+	// Both spend the genesis reward, so only one of them can be mined
+	block := NewBlock([]*Transaction{NewCoinbaseTx(alice, "block 1"), tx, doubleSpend}, bc.tip, 1)
+	assert.ErrorContains(t, bc.CheckBlock(block), "twice")
+	mineTx(bc, alice, tx)
+	block = NewBlock([]*Transaction{NewCoinbaseTx(alice, "block 2"), doubleSpend}, bc.tip, 2)
+	assert.ErrorContains(t, bc.CheckBlock(block), "isn't in the UTXO set", "The output was spent in an earlier block")
+	assert.ErrorIs(t, bc.checkTransaction(doubleSpend, nil), errSpentOutput)
+
+	checked, err := bc.VerifyChain(0, maxVerifyLevel)
+	assert.Nil(t, err, "Connected blocks spent what they spend")
+	assert.Equal(t, 2, checked)
+}
@@ -13,10 +13,12 @@ import (
 	"log"
 	"math/big"
 	"os"
+	"sort"
 )
 
 const addressGenerationVersion = byte(0x00)
 const addressChecksumLen = 4
+const pubKeyHashLen = 20 // RIPEMD160
 const walletFile = "wallet_%s.dat"
 
 type Wallet struct {
@@ -27,9 +29,69 @@ type Wallet struct {
 type WalletData struct {
 	PublicKeyX, PublicKeyY *big.Int
 	PrivateKeyD            *big.Int
+	Change                 bool   // internal address that only ever receives change
+	Owner                  string // for a change address, the address whose spend it received change from
+	Label                  string
+	WatchOnly              bool // imported address we track but hold no keys for
+}
+
+const (
+	addressKindExternal  = "external"
+	addressKindChange    = "change"
+	addressKindWatchOnly = "watch-only"
+)
+
+// walletVersion is the version of the wallet file layout written by this go-chain. Files
+// from before versioning decode with Version 0.
+const walletVersion = 2
+
+// walletMigrations upgrade a wallet read from an older file, indexed by the version they upgrade
+// from. Fields added to WalletData so far decode to safe zero values and need nothing doing.
+var walletMigrations = []func(ws *Wallets) error{
+	func(ws *Wallets) error { return nil }, // 0 -> 1: record the file version
+	rekeyLegacyAddresses,                   // 1 -> 2: file addresses under the padded public key
+}
+
+// rekeyLegacyAddresses files every key under the address it derives now. Addresses used to be
+// derived from public keys with unpadded coordinates and encoded without the leading zero bytes
+// of the pubkey hash, so a key with a short coordinate or a hash starting with a zero byte was
+// filed under an address its wallet no longer answers to.
+func rekeyLegacyAddresses(ws *Wallets) error {
+	renamed := make(map[string]string)
+	for address, walletData := range ws.WalletDatas {
+		if walletData.WatchOnly {
+			continue
+		}
+		current := fmt.Sprintf("%s", walletData.GetWallet().GetAddress())
+		if current != address {
+			renamed[address] = current
+		}
+	}
+
+	for legacy, current := range renamed {
+		walletData := ws.WalletDatas[legacy]
+		ws.WalletDatas[current] = walletData
+		delete(ws.WalletDatas, legacy)
+
+		// Coins are locked to the pubkey hash, so they only stay with the key if the hash is unchanged
+		publicKey := walletData.GetWallet().PrivateKey.PublicKey
+		legacyPubKey := append(publicKey.X.Bytes(), publicKey.Y.Bytes()...)
+		if bytes.Equal(HashPubKey(legacyPubKey), ConvertBase58AddressToPubKeyHash(current)) {
+			fmt.Printf("Address %s is now written %s\n", legacy, current)
+		} else {
+			fmt.Printf("Address %s is now %s, coins sent to the old address can't be spent as its public key was truncated\n", legacy, current)
+		}
+	}
+	for _, walletData := range ws.WalletDatas {
+		if current, ok := renamed[walletData.Owner]; ok {
+			walletData.Owner = current
+		}
+	}
+	return nil
 }
 
 type Wallets struct {
+	Version     int
 	WalletDatas map[string]*WalletData
 }
 
@@ -51,22 +113,105 @@ func (ws *Wallets) LoadFromFile(nodeID string) error {
 	if err != nil {
 		return err
 	}
+	if wallets.Version > walletVersion {
+		return fmt.Errorf("wallet file %s has version %d, but this go-chain only understands up to version %d; upgrade go-chain to open it",
+			walletFile, wallets.Version, walletVersion)
+	}
+
+	if wallets.Version < walletVersion {
+		backup := fmt.Sprintf("%s.v%d.bak", walletFile, wallets.Version)
+		if err := os.WriteFile(backup, file, 0644); err != nil {
+			return err
+		}
+		fmt.Printf("Backed up the wallet to %s\n", backup)
+		for version := wallets.Version; version < walletVersion; version++ {
+			if err := walletMigrations[version](&wallets); err != nil {
+				return err
+			}
+		}
+		wallets.SaveToFile(nodeID)
+	}
+
+	ws.Version = walletVersion
 	ws.WalletDatas = wallets.WalletDatas
 	return nil
 }
 
-func (ws Wallets) CreateWallet() string {
+func (ws Wallets) CreateWallet(label string) string {
 	walletData := NewWalletData()
+	walletData.Label = label
 	address := fmt.Sprintf("%s", walletData.GetWallet().GetAddress())
 	ws.WalletDatas[address] = walletData
 	return address
 }
 
+// CreateChangeWallet creates a fresh internal address to receive the change from a single spend by owner.
+// Sending change back to the paying address would publicly link every payment it makes.
+func (ws Wallets) CreateChangeWallet(owner string) string {
+	if ownerData, ok := ws.WalletDatas[owner]; ok && ownerData.Change {
+		owner = ownerData.Owner // change from spending change still belongs to the original address
+	}
+	walletData := NewWalletData()
+	walletData.Change = true
+	walletData.Owner = owner
+	address := fmt.Sprintf("%s", walletData.GetWallet().GetAddress())
+	ws.WalletDatas[address] = walletData
+	return address
+}
+
+// GetAddresses returns every address in the wallet (external and change) in a stable order
+func (ws Wallets) GetAddresses() []string {
+	var addresses []string
+	for address := range ws.WalletDatas {
+		addresses = append(addresses, address)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// GetSpendingAddresses returns address followed by the change addresses that hold its change.
+// A spend from address can draw on all of them, and together they make up its balance.
+func (ws Wallets) GetSpendingAddresses(address string) []string {
+	addresses := []string{address}
+	for _, candidate := range ws.GetAddresses() {
+		if walletData := ws.WalletDatas[candidate]; walletData.Change && walletData.Owner == address {
+			addresses = append(addresses, candidate)
+		}
+	}
+	return addresses
+}
+
+// ImportWatchOnly starts tracking an address the wallet has no keys for
+func (ws Wallets) ImportWatchOnly(address, label string) error {
+	if !ValidateAddress(address) {
+		return fmt.Errorf("invalid address %q", address)
+	}
+	if _, ok := ws.WalletDatas[address]; ok {
+		return fmt.Errorf("address %q is already in the wallet", address)
+	}
+	ws.WalletDatas[address] = &WalletData{Label: label, WatchOnly: true}
+	return nil
+}
+
+// Kind describes how address came to be in the wallet (external, change or watch-only)
+func (ws Wallets) Kind(address string) string {
+	walletData := ws.WalletDatas[address]
+	switch {
+	case walletData.WatchOnly:
+		return addressKindWatchOnly
+	case walletData.Change:
+		return addressKindChange
+	default:
+		return addressKindExternal
+	}
+}
+
 // SaveToFile saves wallets to a file
 func (ws Wallets) SaveToFile(nodeID string) {
 	var content bytes.Buffer
 	walletFile := fmt.Sprintf(walletFile, nodeID)
 
+	ws.Version = walletVersion
 	encoder := gob.NewEncoder(&content)
 	if err := encoder.Encode(ws); err != nil {
 		log.Panic(err)
@@ -105,7 +250,7 @@ func newKeyPair() (ecdsa.PrivateKey, []byte) {
 	if err != nil {
 		log.Panic(err)
 	}
-	pubKey := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...) // In ECDSA, public keys are X,Y co-ordinates on a curve
+	pubKey := append(padCoordinate(private.PublicKey.X), padCoordinate(private.PublicKey.Y)...) // In ECDSA, public keys are X,Y co-ordinates on a curve
 	return *private, pubKey
 }
 
@@ -130,7 +275,7 @@ func (walletData *WalletData) GetWallet() *Wallet {
 		PublicKey: publicKey,
 		D:         walletData.PrivateKeyD,
 	}
-	pubKeyBytes := append(publicKey.X.Bytes(), publicKey.Y.Bytes()...)
+	pubKeyBytes := append(padCoordinate(publicKey.X), padCoordinate(publicKey.Y)...)
 	return &Wallet{privateKey, pubKeyBytes}
 }
 
@@ -151,6 +296,17 @@ func HashPubKey(pubKey []byte) []byte {
 
 }
 
+// ValidateAddress checks address decodes to a pubkey hash with our version byte and a matching checksum
+func ValidateAddress(address string) bool {
+	payload := Base58Decode([]byte(address))
+	if len(payload) != 1+pubKeyHashLen+addressChecksumLen || payload[0] != addressGenerationVersion {
+		return false
+	}
+	versionedPayload := payload[:len(payload)-addressChecksumLen]
+	actualChecksum := payload[len(payload)-addressChecksumLen:]
+	return bytes.Equal(actualChecksum, checksum(versionedPayload))
+}
+
 func ConvertBase58AddressToPubKeyHash(address string) []byte {
 	return ConvertBase58BytesToPubKeyHash([]byte(address))
 }
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/gob"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+// legacyAddress is the address walletData was filed under before public keys were padded
+func legacyAddress(walletData *WalletData) string {
+	pubKey := append(walletData.PublicKeyX.Bytes(), walletData.PublicKeyY.Bytes()...)
+	payload := append([]byte{addressGenerationVersion}, HashPubKey(pubKey)...)
+	return string(Base58Encode(append(payload, checksum(payload)...)))
+}
+
+// newWalletDataWithLegacyAddress returns a key whose address did (or didn't) change when public keys were padded
+func newWalletDataWithLegacyAddress(changed bool) *WalletData {
+	for {
+		walletData := NewWalletData()
+		if (legacyAddress(walletData) != string(walletData.GetWallet().GetAddress())) == changed {
+			return walletData
+		}
+	}
+}
+
+func TestCreateChangeWallet(t *testing.T) {
+	wallets, addresses := newTestWallets(2)
+	alice, bob := addresses[0], addresses[1]
+
+	change := wallets.CreateChangeWallet(alice)
+	assert.NotEqual(t, alice, change)
+	assert.Equal(t, addressKindChange, wallets.Kind(change))
+	assert.Equal(t, addressKindExternal, wallets.Kind(alice))
+	assert.Equal(t, alice, wallets.WalletDatas[change].Owner)
+
+	changeOfChange := wallets.CreateChangeWallet(change)
+	assert.Equal(t, alice, wallets.WalletDatas[changeOfChange].Owner, "Change from spending change belongs to the original address")
+
+	spending := wallets.GetSpendingAddresses(alice)
+	assert.Equal(t, alice, spending[0])
+	assert.ElementsMatch(t, []string{alice, change, changeOfChange}, spending)
+	assert.Equal(t, []string{bob}, wallets.GetSpendingAddresses(bob))
+	assert.Equal(t, []string{"outsider"}, wallets.GetSpendingAddresses("outsider"))
+}
+
+func TestWalletMigrationRekeysLegacyAddresses(t *testing.T) {
+	inTestDir(t)
+
+	// About one key in a hundred has a coordinate short enough to have been truncated
+	short := newWalletDataWithLegacyAddress(true)
+	legacy, current := legacyAddress(short), string(short.GetWallet().GetAddress())
+	unaffected := newWalletDataWithLegacyAddress(false)
+	unaffectedAddress := legacyAddress(unaffected)
+	change := newWalletDataWithLegacyAddress(false)
+	change.Change, change.Owner = true, legacy
+	changeAddress := legacyAddress(change)
+
+	var content bytes.Buffer
+	old := Wallets{Version: 1, WalletDatas: map[string]*WalletData{legacy: short, unaffectedAddress: unaffected, changeAddress: change}}
+	assert.Nil(t, gob.NewEncoder(&content).Encode(old))
+	assert.Nil(t, os.WriteFile(fmt.Sprintf(walletFile, testNodeID), content.Bytes(), 0644))
+
+	wallets, err := NewWallets(testNodeID)
+	assert.Nil(t, err)
+	assert.Equal(t, walletVersion, wallets.Version)
+	assert.ElementsMatch(t, []string{current, unaffectedAddress, changeAddress}, wallets.GetAddresses())
+	for _, address := range wallets.GetAddresses() {
+		wallet := wallets.GetWallet(address)
+		assert.Equal(t, address, string(wallet.GetAddress()), "Every key is filed under the address it derives")
+	}
+	assert.Equal(t, []string{current, changeAddress}, wallets.GetSpendingAddresses(current), "The change address follows its owner")
+	assert.FileExists(t, fmt.Sprintf(walletFile, testNodeID)+".v1.bak")
+
+	// The re-keyed address can prove ownership again
+	wallet := wallets.GetWallet(current)
+	signature, err := wallet.SignMessage("hello")
+	assert.Nil(t, err)
+	valid, err := VerifyMessage(current, signature, "hello")
+	assert.Nil(t, err)
+	assert.True(t, valid)
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Peers exchange messages over long lived connections. Each message is framed as
+//
+//	magic (4 bytes) | command (12 bytes, zero padded) | length (4 bytes, big endian) | checksum (4 bytes) | payload
+//
+// where the checksum is the first 4 bytes of the double sha256 of the payload (as for addresses).
+// The magic marks the start of every message, so a peer on another network or one that has lost
+// track of the framing is spotted straight away.
+var networkMagic = []byte{0x67, 0x63, 0x68, 0x6e}
+
+const messageHeaderLen = 4 + commandLength + 4 + 4
+
+// maxMessagePayload guards against allocating a huge buffer for a corrupt or hostile length
+const maxMessagePayload = 32 * 1024 * 1024
+
+// maxPayloads limits the payload of each type of message to what the largest valid one needs, so
+// a peer can't make us read megabytes for a message that should be a few bytes. Messages of a type
+// not listed (which are ignored) are limited to defaultMaxPayload.
+var maxPayloads = map[string]uint32{
+	"version":    4 * 1024,
+	"verack":     0,
+	"getaddr":    1024,
+	"addr":       128 * 1024, // maxAddrPerMessage addresses
+	"inventory":  64 * 1024,  // maxInventoryItems hashes
+	"getblocks":  8 * 1024,   // a locator of maxLocatorLen hashes
+	"getheaders": 8 * 1024,
+	"headers":    1024 * 1024, // maxHeadersPerMessage headers
+	"getdata":    1024,
+	"blockdata":  maxMessagePayload,
+	"txdata":     1024 * 1024,
+	"mempool":    1024,
+	"ping":       1024,
+	"pong":       1024,
+}
+
+const defaultMaxPayload = 1024
+
+func maxPayload(command string) uint32 {
+	if max, found := maxPayloads[command]; found {
+		return max
+	}
+	return defaultMaxPayload
+}
+
+var errBadMagic = errors.New("message doesn't start with the network magic")
+
+func writeMessage(w io.Writer, command string, payload []byte) error {
+	if len(payload) > maxMessagePayload {
+		return fmt.Errorf("%s message of %d bytes is too large to send", command, len(payload))
+	}
+	header := make([]byte, 0, messageHeaderLen)
+	header = append(header, networkMagic...)
+	header = append(header, commandToBytes(command)...)
+	header = binary.BigEndian.AppendUint32(header, uint32(len(payload)))
+	header = append(header, checksum(payload)...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// readMessage reads the next message, returning io.EOF if the connection closed cleanly between messages
+func readMessage(r io.Reader) (string, []byte, error) {
+	header := make([]byte, messageHeaderLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return "", nil, err
+	}
+	if !bytes.Equal(header[:4], networkMagic) {
+		return "", nil, errBadMagic
+	}
+	command := bytesToCommand(header[4 : 4+commandLength])
+	length := binary.BigEndian.Uint32(header[4+commandLength:])
+	if length > maxPayload(command) {
+		return command, nil, fmt.Errorf("%s message of %d bytes is too large", command, length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return command, nil, err
+	}
+	if !bytes.Equal(checksum(payload), header[messageHeaderLen-4:]) {
+		return command, nil, fmt.Errorf("%s message has a bad checksum", command)
+	}
+	return command, payload, nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestMessageFraming(t *testing.T) {
+	var buffer bytes.Buffer
+	assert.Nil(t, writeMessage(&buffer, "version", []byte("hello")))
+	assert.Nil(t, writeMessage(&buffer, "verack", nil))
+	data := append([]byte{}, buffer.Bytes()...)
+
+	command, payload, err := readMessage(&buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "version", command)
+	assert.Equal(t, []byte("hello"), payload)
+	command, payload, err = readMessage(&buffer)
+	assert.Nil(t, err)
+	assert.Equal(t, "verack", command)
+	assert.Empty(t, payload)
+	_, _, err = readMessage(&buffer)
+	assert.Equal(t, io.EOF, err, "A clean end between messages")
+
+	_, _, err = readMessage(bytes.NewReader(data[:messageHeaderLen+2]))
+	assert.Equal(t, io.ErrUnexpectedEOF, err, "The connection closed part way through a message")
+
+	data[messageHeaderLen] ^= 0xff
+	_, _, err = readMessage(bytes.NewReader(data))
+	assert.ErrorContains(t, err, "checksum")
+
+	data[0] ^= 0xff
+	_, _, err = readMessage(bytes.NewReader(data))
+	assert.Equal(t, errBadMagic, err)
+}
+
+func TestMessageSizeLimits(t *testing.T) {
+	var buffer bytes.Buffer
+	assert.Nil(t, writeMessage(&buffer, "verack", []byte("padding")))
+	_, _, err := readMessage(&buffer)
+	assert.ErrorContains(t, err, "too large", "Each type of message has its own limit")
+	buffer.Reset()
+	assert.Nil(t, writeMessage(&buffer, "unknown", make([]byte, defaultMaxPayload+1)))
+	_, _, err = readMessage(&buffer)
+	assert.ErrorContains(t, err, "too large")
+
+	// The largest valid messages fit in their limits
+	hash := make([]byte, hashLen)
+	header := BlockHeader{Timestamp: 1700000000, PrevBlockHash: hash, Hash: hash, MerkleRoot: hash, Nonce: 1 << 40, Height: 1 << 30}
+	headers := make([]BlockHeader, maxHeadersPerMessage)
+	for i := range headers {
+		headers[i] = header
+	}
+	assert.LessOrEqual(t, len(gobEncode(Headers{"localhost:3000", headers})), int(maxPayload("headers")))
+	hashes := make([][]byte, maxInventoryItems)
+	for i := range hashes {
+		hashes[i] = hash
+	}
+	assert.LessOrEqual(t, len(gobEncode(Inventory{"localhost:3000", "block", hashes})), int(maxPayload("inventory")))
+	assert.LessOrEqual(t, len(gobEncode(GetHeaders{"localhost:3000", hashes[:maxLocatorLen], hash})), int(maxPayload("getheaders")))
+	addresses := make([]NetAddress, maxAddrPerMessage)
+	for i := range addresses {
+		addresses[i] = NetAddress{"[2001:db8::1234:5678]:65535", serviceNetwork | serviceNetworkLimited, 1700000000}
+	}
+	assert.LessOrEqual(t, len(gobEncode(Addr{"localhost:3000", addresses})), int(maxPayload("addr")))
+}